@@ -1,24 +1,125 @@
+// Package balancer picks the backend of each call among the addresses of a
+// target. The round tripper a balancer picks is a *SubConn, which tells the
+// backend it targets.
+//
+// Balancers are built for an endpoint by the builder registered under their
+// name, see Register, and resolve it with the resolver registered for its
+// scheme, see resolver.ParseTarget. Registering a builder from e.g.
+// NewRoundRobinBuilder replaces the one registered by default under the same
+// name.
 package balancer
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"strings"
 
 	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/metadata"
+
+	"github.com/vimcoders/grpcx/orca"
 )
 
 // Picker is the interface for picking a round tripper from a list of round trippers.
 type Picker interface {
-	Pick(context.Context, PickInfo) (roundtrip.RoundTripper, error)
+	Pick(context.Context, PickInfo) (PickResult, error)
 	io.Closer
 }
 
+// PickResult is the result of a pick, the round tripper of the call.
+type PickResult struct {
+	RoundTripper roundtrip.RoundTripper
+	// Done is called once the call finished, with how it finished. It may be
+	// nil, balancers which do not track their calls leave it unset.
+	Done func(DoneInfo)
+}
+
+// DoneInfo contains information about a finished call.
+type DoneInfo struct {
+	// Err is the error the call finished with, nil if it succeeded.
+	Err error
+	// ServerLoad is the load report the server attached to the call, nil if
+	// there is none, see package orca.
+	ServerLoad *orca.LoadReport
+}
+
 // PickInfo contains information about the request being made.
 type PickInfo struct {
 	FullMethodName string // 请求方法名
+	// Metadata is the outgoing metadata of the call, see metadata.WithMetadata.
+	Metadata metadata.MD
+	// HashKey is the key of the call set with WithHashKey, balancers with
+	// affinity send the calls with the same key to the same backend.
+	HashKey string
+}
+
+// hashKey is the context key of the hash key of a call.
+type hashKey struct{}
+
+// WithHashKey returns a context whose calls carry the hash key, e.g. a user
+// ID, in PickInfo.
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKey returns the hash key set on the context with WithHashKey.
+func HashKey(ctx context.Context) string {
+	key, _ := ctx.Value(hashKey{}).(string)
+	return key
+}
+
+// subsetKey is the context key of the subset of the balancers built.
+type subsetKey struct{}
+
+// subset is the subset of the addresses of a target kept for a client.
+type subset struct {
+	clientID string
+	size     int
+}
+
+// WithSubset returns a context building balancers which keep a subset of
+// size of the addresses of their target for the client, see resolver.Subset.
+// Unlike registering resolver.NewSubsetBuilder, it only affects the balancers
+// built with the context.
+func WithSubset(ctx context.Context, clientID string, size int) context.Context {
+	return context.WithValue(ctx, subsetKey{}, subset{clientID: clientID, size: size})
 }
 
 // Builder is the interface for building a balancer.
 type Builder interface {
 	Build(ctx context.Context, endpoint string, opts ...roundtrip.Option) (Picker, error)
+	// Name returns the name of the balancer, it selects the balancer in
+	// Get and in the service config.
+	Name() string
+}
+
+// builders holds the registered balancers by name.
+var builders = make(map[string]Builder)
+
+// Register registers the balancer builder under its name, which is case
+// insensitive. A builder registered later with the same name replaces the
+// earlier one.
+//
+// Register must only be called during initialization, e.g. from an init
+// function, it is not safe for concurrent use.
+func Register(b Builder) {
+	builders[strings.ToLower(b.Name())] = b
+}
+
+// Get returns the balancer builder registered with the given name. It
+// returns nil if no builder is registered with that name.
+func Get(name string) Builder {
+	return builders[strings.ToLower(name)]
+}
+
+// Build builds a balancer with the builder registered with the given name
+// for the endpoint.
+func Build(ctx context.Context, name string, endpoint string, opts ...roundtrip.Option) (Picker, error) {
+	b := Get(name)
+	if b == nil {
+		return nil, fmt.Errorf("balancer: no balancer registered with name %q", name)
+	}
+	return b.Build(ctx, endpoint, opts...)
 }
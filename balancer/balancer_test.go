@@ -0,0 +1,66 @@
+package balancer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+)
+
+// namedBuilder is a builder which only has a name.
+type namedBuilder struct {
+	name string
+}
+
+func (b *namedBuilder) Build(ctx context.Context, endpoint string, opts ...roundtrip.Option) (Picker, error) {
+	return nil, nil
+}
+
+func (b *namedBuilder) Name() string {
+	return b.name
+}
+
+func TestRegister(t *testing.T) {
+	b := &namedBuilder{name: "Test_Register"}
+	Register(b)
+	if got := Get("test_register"); got != b {
+		t.Fatalf("got %v, want %v", got, b)
+	}
+	if got := Get(RoundRobinName); got == nil {
+		t.Fatal("round robin balancer is not registered")
+	}
+	if got := Get("unknown"); got != nil {
+		t.Fatalf("got %v, want nil", got)
+	}
+	if _, err := Build(context.Background(), "unknown", "127.0.0.1:0"); err == nil {
+		t.Fatal("building an unknown balancer succeeded")
+	}
+}
+
+func TestPolicyFromServiceConfig(t *testing.T) {
+	Register(&namedBuilder{name: "test_policy"})
+	tests := []struct {
+		js      string
+		want    string
+		wantErr bool
+	}{
+		{js: `{}`, want: ""},
+		{js: `{"loadBalancingPolicy": "ROUND_ROBIN"}`, want: "ROUND_ROBIN"},
+		{js: `{"loadBalancingConfig": [{"round_robin": {}}]}`, want: "round_robin"},
+		{js: `{"loadBalancingConfig": [{"unknown": {}}, {"test_policy": {"key": 1}}]}`, want: "test_policy"},
+		{js: `{"loadBalancingPolicy": "round_robin", "loadBalancingConfig": [{"test_policy": {}}]}`, want: "test_policy"},
+		{js: `{"loadBalancingPolicy": "unknown"}`, wantErr: true},
+		{js: `{"loadBalancingConfig": [{"unknown": {}}]}`, wantErr: true},
+		{js: `{"loadBalancingConfig": [{"round_robin": {}, "test_policy": {}}]}`, wantErr: true},
+		{js: `not json`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := PolicyFromServiceConfig(tt.js)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("PolicyFromServiceConfig(%s) error = %v, want error %v", tt.js, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("PolicyFromServiceConfig(%s) = %q, want %q", tt.js, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// serviceConfig is the part of a gRPC service config selecting the balancer.
+type serviceConfig struct {
+	LoadBalancingPolicy string                       `json:"loadBalancingPolicy"`
+	LoadBalancingConfig []map[string]json.RawMessage `json:"loadBalancingConfig"`
+}
+
+// PolicyFromServiceConfig returns the name of the balancer selected by the
+// given service config in JSON, as described in
+// https://github.com/grpc/grpc/blob/master/doc/service_config.md, e.g.
+//
+//	{"loadBalancingConfig": [{"round_robin": {}}]}
+//
+// The first policy of loadBalancingConfig which is registered is selected,
+// otherwise loadBalancingPolicy. The configuration of the policy is ignored.
+// It returns an empty name if the service config selects no balancer.
+func PolicyFromServiceConfig(js string) (string, error) {
+	var sc serviceConfig
+	if err := json.Unmarshal([]byte(js), &sc); err != nil {
+		return "", fmt.Errorf("balancer: invalid service config: %w", err)
+	}
+	if len(sc.LoadBalancingConfig) == 0 {
+		if sc.LoadBalancingPolicy != "" && Get(sc.LoadBalancingPolicy) == nil {
+			return "", fmt.Errorf("balancer: no balancer registered with name %q", sc.LoadBalancingPolicy)
+		}
+		return sc.LoadBalancingPolicy, nil
+	}
+	for _, lbc := range sc.LoadBalancingConfig {
+		if len(lbc) != 1 {
+			return "", fmt.Errorf("balancer: invalid service config: loadBalancingConfig entry with %d policies", len(lbc))
+		}
+		for name := range lbc {
+			if Get(name) != nil {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("balancer: invalid service config: no registered balancer in loadBalancingConfig")
+}
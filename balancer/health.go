@@ -0,0 +1,79 @@
+package balancer
+
+import (
+	"context"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// defaultHealthRetry is the time waited before watching the health of a
+// backend again once the watch failed.
+const defaultHealthRetry = time.Second
+
+// GetState returns the connectivity state of the SubConn. A SubConn whose
+// backend reports it is not serving, see grpc.health.v1.Health, is in
+// TransientFailure although connected, so that balancers stop picking it.
+func (sc *SubConn) GetState() connectivity.State {
+	state := sc.RoundTripper.GetState()
+	if state == connectivity.Ready && sc.notServing.Load() {
+		return connectivity.TransientFailure
+	}
+	return state
+}
+
+// Close stops the health watch of the SubConn and closes it.
+func (sc *SubConn) Close() error {
+	if sc.stopHealth != nil {
+		sc.stopHealth()
+	}
+	return sc.RoundTripper.Close()
+}
+
+// watchHealth watches the serving status of the backend of the SubConn until
+// it is closed. Backends which do not implement the health service are
+// serving.
+func (sc *SubConn) watchHealth() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sc.stopHealth = cancel
+	go func() {
+		client := healthpb.NewHealthClient(sc.RoundTripper)
+		for {
+			err := sc.recvHealth(ctx, client)
+			// Only a backend reporting it is not serving is skipped, the
+			// state of the connection tells whether it is reachable.
+			sc.notServing.Store(false)
+			if ctx.Err() != nil || status.Code(err) == codes.Unimplemented {
+				return
+			}
+			timer := time.NewTimer(defaultHealthRetry)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// recvHealth receives the serving status of the backend until the watch
+// fails.
+func (sc *SubConn) recvHealth(ctx context.Context, client healthpb.HealthClient) error {
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		sc.notServing.Store(resp.Status != healthpb.HealthCheckResponse_SERVING)
+	}
+}
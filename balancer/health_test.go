@@ -0,0 +1,60 @@
+package balancer
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// newHealthBackend serves the echo service and the health service on a
+// random local port and returns its address.
+func newHealthBackend(t *testing.T) (string, *health.Server) {
+	t.Helper()
+	hs := health.NewServer()
+	return newSlowBackend(t, 0, roundtrip.RegisterService(&healthpb.Health_ServiceDesc, hs)), hs
+}
+
+// waitForState waits until sc is in state.
+func waitForState(t *testing.T, sc *SubConn, state connectivity.State) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for sc.GetState() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v not %v", sc, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHealthCheckSkipsNotServing(t *testing.T) {
+	addr1, hs1 := newHealthBackend(t)
+	addr2, _ := newHealthBackend(t)
+	// A backend without the health service is serving.
+	addr3 := newBackend(t)
+	rr, err := newRoundRobin(context.Background(), newPushResolver(addr1, addr2, addr3), url.URL{}, dialAddress, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	subConns := rr.SubConns()
+	i := slices.IndexFunc(subConns, func(sc *SubConn) bool { return sc.Address().Addr == addr1 })
+	// The backend is drained, it is no longer picked.
+	hs1.Shutdown()
+	waitForState(t, subConns[i], connectivity.TransientFailure)
+	if got, want := pickBackends(t, rr, 10), sorted(addr2, addr3); !slices.Equal(got, want) {
+		t.Fatalf("got backends %v, want %v", got, want)
+	}
+	hs1.Resume()
+	waitForState(t, subConns[i], connectivity.Ready)
+	if got, want := pickBackends(t, rr, 10), sorted(addr1, addr2, addr3); !slices.Equal(got, want) {
+		t.Fatalf("got backends %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package balancer
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLatencyDecay is the time constant of the moving average of the
+// latency of a SubConn, older calls weigh less as time passes.
+const defaultLatencyDecay = time.Second * 10
+
+// load tracks the calls in flight on a SubConn, the exponentially weighted
+// moving average of their latency and the load reported by the backend.
+type load struct {
+	inflight atomic.Int64
+	mu       sync.Mutex
+	latency  float64
+	updated  time.Time
+	// serverWeight is the weight derived from the last load report of the
+	// backend, received at serverWeightUpdated.
+	serverWeight        float64
+	serverWeightUpdated time.Time
+}
+
+// start records a call picked for the SubConn.
+func (l *load) start() {
+	l.inflight.Add(1)
+}
+
+// finish records the end of a call started with start, which took latency.
+func (l *load) finish(latency time.Duration) {
+	l.inflight.Add(-1)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.updated.IsZero() {
+		l.latency = float64(latency)
+	} else {
+		w := math.Exp(-float64(now.Sub(l.updated)) / float64(defaultLatencyDecay))
+		l.latency = l.latency*w + float64(latency)*(1-w)
+	}
+	l.updated = now
+}
+
+// average returns the moving average of the latency, zero before the first
+// call finished.
+func (l *load) average() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Duration(l.latency)
+}
+
+// setServerWeight records the weight derived from a load report of the backend.
+func (l *load) setServerWeight(weight float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.serverWeight = weight
+	l.serverWeightUpdated = time.Now()
+}
+
+// serverWeightSince returns the weight derived from the last load report of
+// the backend, if it was received after t.
+func (l *load) serverWeightSince(t time.Time) (float64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.serverWeight <= 0 || l.serverWeightUpdated.Before(t) {
+		return 0, false
+	}
+	return l.serverWeight, true
+}
+
+// InFlight returns the number of calls picked by a load aware balancer which
+// are in flight on the SubConn.
+func (sc *SubConn) InFlight() int64 {
+	return sc.load.inflight.Load()
+}
+
+// Latency returns the moving average of the latency of the calls picked by a
+// load aware balancer for the SubConn, zero before the first one finished.
+func (sc *SubConn) Latency() time.Duration {
+	return sc.load.average()
+}
@@ -0,0 +1,234 @@
+package balancer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/resolver"
+
+	"google.golang.org/grpc/codes"
+)
+
+// OutlierDetectionConfig configures the passive outlier detection of the
+// round robin balancer. Backends are ejected, no longer picked, when their
+// calls fail: at once after ConsecutiveFailures failures in a row, or at the
+// end of an Interval in which at least FailurePercentage percent of at least
+// RequestVolume calls failed.
+//
+// A backend is ejected for BaseEjectionTime, doubled every time it is
+// ejected again up to MaxEjectionTime, and halved back for every Interval it
+// stays healthy. At most MaxEjectionPercent percent of the backends, but at
+// least one, are ejected at the same time. Fields left zero take the value of
+// DefaultOutlierDetectionConfig.
+//
+// Calls fail when they end with Unavailable, Internal, Unknown or DataLoss,
+// other errors are the fault of the caller.
+type OutlierDetectionConfig struct {
+	Interval            time.Duration
+	BaseEjectionTime    time.Duration
+	MaxEjectionTime     time.Duration
+	MaxEjectionPercent  int
+	ConsecutiveFailures int
+	FailurePercentage   int
+	RequestVolume       int
+	// OnEjection, if set, is called when a backend is ejected or returns.
+	OnEjection func(OutlierEvent)
+}
+
+// DefaultOutlierDetectionConfig holds the values of the fields left zero in an
+// OutlierDetectionConfig.
+var DefaultOutlierDetectionConfig = OutlierDetectionConfig{
+	Interval:            time.Second * 10,
+	BaseEjectionTime:    time.Second * 30,
+	MaxEjectionTime:     time.Minute * 5,
+	MaxEjectionPercent:  10,
+	ConsecutiveFailures: 5,
+	FailurePercentage:   50,
+	RequestVolume:       20,
+}
+
+// withDefaults returns the config with its zero fields set to their default.
+func (c OutlierDetectionConfig) withDefaults() OutlierDetectionConfig {
+	d := DefaultOutlierDetectionConfig
+	if c.Interval <= 0 {
+		c.Interval = d.Interval
+	}
+	if c.BaseEjectionTime <= 0 {
+		c.BaseEjectionTime = d.BaseEjectionTime
+	}
+	if c.MaxEjectionTime <= 0 {
+		c.MaxEjectionTime = d.MaxEjectionTime
+	}
+	if c.MaxEjectionPercent <= 0 {
+		c.MaxEjectionPercent = d.MaxEjectionPercent
+	}
+	if c.ConsecutiveFailures <= 0 {
+		c.ConsecutiveFailures = d.ConsecutiveFailures
+	}
+	if c.FailurePercentage <= 0 {
+		c.FailurePercentage = d.FailurePercentage
+	}
+	if c.RequestVolume <= 0 {
+		c.RequestVolume = d.RequestVolume
+	}
+	return c
+}
+
+// OutlierEvent is the ejection of a backend, or its return.
+type OutlierEvent struct {
+	Address resolver.Address
+	// Ejected is false when the backend returns.
+	Ejected bool
+	// Reason tells why the backend was ejected, empty when it returns.
+	Reason string
+	// Duration is the time the backend is ejected for.
+	Duration time.Duration
+}
+
+const (
+	// reasonConsecutiveFailures is the reason of the ejection of a backend
+	// whose last calls all failed.
+	reasonConsecutiveFailures = "consecutive failures"
+	// reasonFailurePercentage is the reason of the ejection of a backend
+	// whose calls failed too often during an interval.
+	reasonFailurePercentage = "failure percentage"
+)
+
+// outlierStats are the calls of a SubConn counted by the outlier detection.
+type outlierStats struct {
+	successes   atomic.Int64
+	failures    atomic.Int64
+	consecutive atomic.Int64
+	ejected     atomic.Bool
+	// ejectedUntil and multiplier are guarded by the outlierDetector.
+	ejectedUntil time.Time
+	multiplier   int
+}
+
+// outlierDetector ejects the SubConns of a balancer whose calls fail.
+type outlierDetector struct {
+	OutlierDetectionConfig
+	mu sync.Mutex
+}
+
+// newOutlierDetector creates an outlier detector with the config, its zero
+// fields set to their default.
+func newOutlierDetector(c OutlierDetectionConfig) *outlierDetector {
+	return &outlierDetector{OutlierDetectionConfig: c.withDefaults()}
+}
+
+// failed reports whether a call ending with err counts as a failure of the
+// backend.
+func failed(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// done records a call on sc which ended with err, sc is ejected once too many
+// calls failed in a row. subConns returns the SubConns of the balancer.
+func (d *outlierDetector) done(sc *SubConn, subConns func() []*SubConn, err error) {
+	stats := &sc.outlier
+	if !failed(err) {
+		stats.successes.Add(1)
+		stats.consecutive.Store(0)
+		return
+	}
+	stats.failures.Add(1)
+	if stats.consecutive.Add(1) < int64(d.ConsecutiveFailures) {
+		return
+	}
+	d.mu.Lock()
+	event, ok := d.ejectLocked(sc, subConns(), time.Now(), reasonConsecutiveFailures)
+	d.mu.Unlock()
+	if ok {
+		d.notify(event)
+	}
+}
+
+// ejectLocked ejects sc unless it is ejected already or too many SubConns
+// are. It reports whether sc was ejected.
+func (d *outlierDetector) ejectLocked(sc *SubConn, subConns []*SubConn, now time.Time, reason string) (OutlierEvent, bool) {
+	stats := &sc.outlier
+	if stats.ejected.Load() {
+		return OutlierEvent{}, false
+	}
+	var ejected int
+	for _, sc := range subConns {
+		if sc.outlier.ejected.Load() {
+			ejected++
+		}
+	}
+	if ejected >= max(1, len(subConns)*d.MaxEjectionPercent/100) {
+		return OutlierEvent{}, false
+	}
+	stats.multiplier++
+	duration := d.BaseEjectionTime
+	for i := 1; i < stats.multiplier && duration < d.MaxEjectionTime; i++ {
+		duration *= 2
+	}
+	duration = min(duration, d.MaxEjectionTime)
+	stats.ejectedUntil = now.Add(duration)
+	stats.consecutive.Store(0)
+	stats.ejected.Store(true)
+	return OutlierEvent{Address: sc.Address(), Ejected: true, Reason: reason, Duration: duration}, true
+}
+
+// sweep ends an interval: SubConns whose ejection is over return, those
+// whose calls failed too often during the interval are ejected.
+func (d *outlierDetector) sweep(subConns []*SubConn, now time.Time) {
+	var events []OutlierEvent
+	d.mu.Lock()
+	for _, sc := range subConns {
+		stats := &sc.outlier
+		successes, failures := stats.successes.Swap(0), stats.failures.Swap(0)
+		if stats.ejected.Load() {
+			if !now.Before(stats.ejectedUntil) {
+				stats.ejected.Store(false)
+				events = append(events, OutlierEvent{Address: sc.Address()})
+			}
+			continue
+		}
+		if volume := successes + failures; volume >= int64(d.RequestVolume) && failures*100 >= int64(d.FailurePercentage)*volume {
+			if event, ok := d.ejectLocked(sc, subConns, now, reasonFailurePercentage); ok {
+				events = append(events, event)
+				continue
+			}
+		}
+		if stats.multiplier > 0 {
+			stats.multiplier--
+		}
+	}
+	d.mu.Unlock()
+	for _, event := range events {
+		d.notify(event)
+	}
+}
+
+// run sweeps the SubConns every interval until ctx is done.
+func (d *outlierDetector) run(ctx context.Context, subConns func() []*SubConn) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.sweep(subConns(), now)
+		}
+	}
+}
+
+// notify calls the OnEjection callback.
+func (d *outlierDetector) notify(event OutlierEvent) {
+	if d.OnEjection != nil {
+		d.OnEjection(event)
+	}
+}
@@ -0,0 +1,177 @@
+package balancer
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/resolver"
+)
+
+// eventRecorder records the outlier events.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []OutlierEvent
+}
+
+func (r *eventRecorder) record(event OutlierEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// take returns the events recorded since the last call.
+func (r *eventRecorder) take() []OutlierEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestOutlierConsecutiveFailures(t *testing.T) {
+	var events eventRecorder
+	d := newOutlierDetector(OutlierDetectionConfig{
+		BaseEjectionTime:    time.Second,
+		MaxEjectionTime:     3 * time.Second,
+		MaxEjectionPercent:  50,
+		ConsecutiveFailures: 3,
+		OnEjection:          events.record,
+	})
+	a, b, c, e := fakeSubConn(resolver.Address{Addr: "a"}), fakeSubConn(resolver.Address{Addr: "b"}),
+		fakeSubConn(resolver.Address{Addr: "c"}), fakeSubConn(resolver.Address{Addr: "e"})
+	subConns := []*SubConn{a, b, c, e}
+	list := func() []*SubConn { return subConns }
+	fail := func(sc *SubConn, n int) {
+		for range n {
+			d.done(sc, list, status.Unavailable.Err())
+		}
+	}
+
+	// Errors of the caller and a success in between do not count.
+	fail(a, 2)
+	d.done(a, list, status.InvalidArgument.Err())
+	d.done(a, list, nil)
+	fail(a, 2)
+	if got := events.take(); len(got) != 0 {
+		t.Fatalf("got events %v before %d failures in a row", got, d.ConsecutiveFailures)
+	}
+	fail(a, 1)
+	fail(b, 3)
+	// Half the backends are ejected already.
+	fail(c, 3)
+	want := []OutlierEvent{
+		{Address: a.Address(), Ejected: true, Reason: reasonConsecutiveFailures, Duration: time.Second},
+		{Address: b.Address(), Ejected: true, Reason: reasonConsecutiveFailures, Duration: time.Second},
+	}
+	if got := events.take(); !slices.Equal(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+
+	// Ejected backends return once their ejection is over, a backend ejected
+	// again is ejected for longer.
+	now := time.Now()
+	d.sweep(subConns, now.Add(time.Second))
+	want = []OutlierEvent{{Address: a.Address()}, {Address: b.Address()}}
+	if got := events.take(); !slices.Equal(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for _, duration := range []time.Duration{2 * time.Second, 3 * time.Second} {
+		fail(a, 3)
+		want = []OutlierEvent{{Address: a.Address(), Ejected: true, Reason: reasonConsecutiveFailures, Duration: duration}}
+		if got := events.take(); !slices.Equal(got, want) {
+			t.Fatalf("got events %v, want %v", got, want)
+		}
+		now = now.Add(time.Hour)
+		d.sweep(subConns, now)
+		events.take()
+	}
+	// Healthy intervals shorten the next ejection.
+	for range 3 {
+		now = now.Add(time.Second)
+		d.sweep(subConns, now)
+	}
+	fail(a, 3)
+	want = []OutlierEvent{{Address: a.Address(), Ejected: true, Reason: reasonConsecutiveFailures, Duration: time.Second}}
+	if got := events.take(); !slices.Equal(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+}
+
+func TestOutlierFailurePercentage(t *testing.T) {
+	var events eventRecorder
+	d := newOutlierDetector(OutlierDetectionConfig{
+		MaxEjectionPercent: 50,
+		FailurePercentage:  50,
+		RequestVolume:      10,
+		OnEjection:         events.record,
+	})
+	a, b := fakeSubConn(resolver.Address{Addr: "a"}), fakeSubConn(resolver.Address{Addr: "b"})
+	subConns := []*SubConn{a, b}
+	list := func() []*SubConn { return subConns }
+	calls := func(sc *SubConn, successes, failures int) {
+		for i := range successes + failures {
+			var err error
+			if i%2 == 1 && failures > 0 {
+				err = status.Internal.Err()
+				failures--
+			}
+			d.done(sc, list, err)
+		}
+	}
+
+	calls(a, 6, 4)
+	calls(b, 2, 2)
+	d.sweep(subConns, time.Now())
+	if got := events.take(); len(got) != 0 {
+		t.Fatalf("got events %v", got)
+	}
+	calls(a, 5, 5)
+	d.sweep(subConns, time.Now())
+	want := []OutlierEvent{{Address: a.Address(), Ejected: true, Reason: reasonFailurePercentage, Duration: DefaultOutlierDetectionConfig.BaseEjectionTime}}
+	if got := events.take(); !slices.Equal(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+}
+
+func TestRoundRobinSkipsEjected(t *testing.T) {
+	a, b := newBackend(t), newBackend(t)
+	var events eventRecorder
+	rr, err := newRoundRobin(context.Background(), newPushResolver(a, b), url.URL{}, dialAddress, &OutlierDetectionConfig{
+		MaxEjectionPercent: 50,
+		OnEjection:         events.record,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	for len(events.take()) == 0 {
+		res, err := rr.Pick(context.Background(), PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RoundTripper.(*SubConn).Address().Addr == a {
+			res.Done(DoneInfo{Err: status.Unavailable.Err()})
+		} else {
+			res.Done(DoneInfo{})
+		}
+	}
+	if got, want := pickBackends(t, rr, 4), []string{b}; !slices.Equal(got, want) {
+		t.Fatalf("got backends %v, want %v", got, want)
+	}
+}
+
+func TestOutlierDetectionOptIn(t *testing.T) {
+	if b := Get(RoundRobinName).(*rrBuilder); b.outlierDetection != nil {
+		t.Fatalf("round robin registered by default detects outliers with %+v", *b.outlierDetection)
+	}
+	b := NewRoundRobinBuilder(&DefaultOutlierDetectionConfig).(*rrBuilder)
+	if b.outlierDetection == nil || b.outlierDetection.Interval != DefaultOutlierDetectionConfig.Interval {
+		t.Fatalf("got outlier detection %v, want %+v", b.outlierDetection, DefaultOutlierDetectionConfig)
+	}
+}
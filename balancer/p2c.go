@@ -0,0 +1,102 @@
+package balancer
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/resolver"
+)
+
+// P2CName is the name of the power of two choices balancer.
+const P2CName = "p2c"
+
+func init() {
+	Register(&p2cBuilder{})
+}
+
+// p2cBuilder is the builder of the power of two choices balancer.
+type p2cBuilder struct{}
+
+// Name returns the name of the power of two choices balancer.
+func (b *p2cBuilder) Name() string {
+	return P2CName
+}
+
+// Build builds a power of two choices balancer.
+func (b *p2cBuilder) Build(ctx context.Context, endpoint string, opts ...roundtrip.Option) (Picker, error) {
+	target := resolver.ParseTarget(endpoint)
+	r, err := resolver.Build(target.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return newP2C(ctx, r, target, dialer(opts...))
+}
+
+// newP2C builds a power of two choices balancer over the addresses of the
+// target, it dials a SubConn for each of them.
+func newP2C(ctx context.Context, r resolver.Resolver, target url.URL, dialContext func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error)) (*P2C, error) {
+	var x P2C
+	if err := x.start(ctx, r, target, dialContext); err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
+
+// P2C is a power of two choices balancer: it picks two SubConns at random
+// and uses the less loaded one. The load of a SubConn is the moving average
+// of the latency of its calls times the calls in flight on it, each call
+// picked is tracked until its Done is called.
+type P2C struct {
+	subConnList
+}
+
+// Pick picks the less loaded of two random round trippers which are Ready,
+// or of any two if none is Ready.
+func (p *P2C) Pick(_ context.Context, _ PickInfo) (PickResult, error) {
+	p.RLock()
+	defer p.RUnlock()
+	subConns := readySubConns(p.subConns)
+	if len(subConns) == 0 {
+		return PickResult{}, status.ResourceExhausted.Err()
+	}
+	sc := subConns[0]
+	if n := len(subConns); n > 1 {
+		i, j := rand.Intn(n), rand.Intn(n-1)
+		if j >= i {
+			j++
+		}
+		sc = lessLoaded(subConns[i], subConns[j])
+	}
+	sc.load.start()
+	start := time.Now()
+	return PickResult{
+		RoundTripper: sc,
+		Done: func(DoneInfo) {
+			sc.load.finish(time.Since(start))
+		},
+	}, nil
+}
+
+// lessLoaded returns the less loaded of two SubConns. Until the latency of
+// both is known, only their calls in flight are compared, the one whose
+// latency is unknown wins a tie so that it gets measured.
+func lessLoaded(a, b *SubConn) *SubConn {
+	aLatency, bLatency := a.Latency(), b.Latency()
+	aInFlight, bInFlight := a.InFlight()+1, b.InFlight()+1
+	if aLatency == 0 || bLatency == 0 {
+		if bInFlight < aInFlight || bInFlight == aInFlight && bLatency == 0 && aLatency != 0 {
+			return b
+		}
+		return a
+	}
+	if float64(bLatency)*float64(bInFlight) < float64(aLatency)*float64(aInFlight) {
+		return b
+	}
+	return a
+}
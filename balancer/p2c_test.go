@@ -0,0 +1,66 @@
+package balancer
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/generated/api"
+)
+
+func TestLessLoaded(t *testing.T) {
+	a, b := &SubConn{}, &SubConn{}
+	// Without latencies the calls in flight are compared.
+	b.load.start()
+	if got := lessLoaded(a, b); got != a {
+		t.Fatal("picked the SubConn with more calls in flight")
+	}
+	b.load.finish(10 * time.Millisecond)
+	// A SubConn whose latency is unknown wins a tie.
+	if got := lessLoaded(b, a); got != a {
+		t.Fatal("picked the SubConn whose latency is known")
+	}
+	a.load.start()
+	a.load.finish(time.Millisecond)
+	if got := lessLoaded(b, a); got != a {
+		t.Fatal("picked the slower SubConn")
+	}
+	// The faster SubConn is more loaded once enough calls are in flight on it.
+	for range 20 {
+		a.load.start()
+	}
+	if got := lessLoaded(a, b); got != b {
+		t.Fatalf("picked the SubConn with %d calls in flight", a.InFlight())
+	}
+}
+
+func TestP2CPrefersFasterBackend(t *testing.T) {
+	fast, slow := newBackend(t), newSlowBackend(t, 20*time.Millisecond)
+	p, err := newP2C(context.Background(), newPushResolver(fast, slow), url.URL{}, dialAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	served := make(map[string]int)
+	for range 50 {
+		res, err := p.Pick(context.Background(), PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := api.NewEchoServiceClient(res.RoundTripper).Echo(context.Background(), &api.EchoRequest{})
+		res.Done(DoneInfo{Err: err})
+		if err != nil {
+			t.Fatal(err)
+		}
+		served[resp.Message]++
+	}
+	if served[fast] < 45 {
+		t.Fatalf("fast backend served %d calls, slow one %d", served[fast], served[slow])
+	}
+	for _, sc := range p.SubConns() {
+		if got := sc.InFlight(); got != 0 {
+			t.Fatalf("%v has %d calls in flight after all finished", sc, got)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package balancer
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"math/rand"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/resolver"
+
+	"github.com/cespare/xxhash/v2"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	// RingHashName is the name of the ring hash balancer.
+	RingHashName = "ring_hash"
+	// HashKeyHeader is the metadata key the ring hash balancer takes the
+	// hash key of a call from when PickInfo.HashKey is empty.
+	HashKeyHeader = "x-hash-key"
+	// defaultReplicas is the number of entries of a backend on the ring per
+	// unit of weight, the more there are the more evenly the keys spread
+	// across the backends.
+	defaultReplicas = 128
+	// defaultMaxRingSize is the maximum number of entries of the ring, the
+	// entries of all backends are scaled down beyond.
+	defaultMaxRingSize = 1 << 16
+)
+
+func init() {
+	Register(&ringHashBuilder{})
+}
+
+// ringHashBuilder is the builder of the ring hash balancer.
+type ringHashBuilder struct{}
+
+// Name returns the name of the ring hash balancer.
+func (b *ringHashBuilder) Name() string {
+	return RingHashName
+}
+
+// Build builds a ring hash balancer.
+func (b *ringHashBuilder) Build(ctx context.Context, endpoint string, opts ...roundtrip.Option) (Picker, error) {
+	target := resolver.ParseTarget(endpoint)
+	r, err := resolver.Build(target.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return newRingHash(ctx, r, target, dialer(opts...))
+}
+
+// newRingHash builds a ring hash balancer over the addresses of the target,
+// it dials a SubConn for each of them.
+func newRingHash(ctx context.Context, r resolver.Resolver, target url.URL, dialContext func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error)) (*RingHash, error) {
+	x := &RingHash{}
+	x.updated = func(subConns []*SubConn) {
+		x.ring = newRing(subConns)
+	}
+	if err := x.start(ctx, r, target, dialContext); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// RingHash is a consistent hashing balancer: the calls with the same hash
+// key go to the same backend, and when a backend joins or leaves only the
+// keys of about one backend move. The key of a call is PickInfo.HashKey, set
+// with WithHashKey, or else the value of HashKeyHeader in its metadata.
+// Calls without a key are spread at random.
+//
+// Each backend takes a share of the ring proportional to the weight of its
+// address, see resolver.Weight.
+type RingHash struct {
+	subConnList
+	ring ring
+}
+
+// Pick picks the round tripper of the backend owning the hash key of the
+// call. If it is not Ready, the next backend on the ring which is Ready is
+// picked, if none is the owner is picked.
+func (rh *RingHash) Pick(_ context.Context, info PickInfo) (PickResult, error) {
+	rh.RLock()
+	defer rh.RUnlock()
+	if len(rh.ring) == 0 {
+		return PickResult{}, status.ResourceExhausted.Err()
+	}
+	key := info.HashKey
+	if key == "" {
+		key, _ = info.Metadata.Get(HashKeyHeader)
+	}
+	var i int
+	if key == "" {
+		i = rand.Intn(len(rh.ring))
+	} else {
+		i = rh.ring.lookup(xxhash.Sum64String(key))
+	}
+	for j := range len(rh.ring) {
+		sc := rh.ring[(i+j)%len(rh.ring)].subConn
+		if sc.GetState() == connectivity.Ready {
+			return PickResult{RoundTripper: sc}, nil
+		}
+	}
+	return PickResult{RoundTripper: rh.ring[i].subConn}, nil
+}
+
+// ringEntry is a point of the ring owned by a SubConn.
+type ringEntry struct {
+	hash    uint64
+	subConn *SubConn
+}
+
+// ring is a hash ring sorted by hash. A key is owned by the SubConn of the
+// first entry whose hash is not lower than the hash of the key.
+type ring []ringEntry
+
+// newRing builds the ring of the SubConns. The entries of a SubConn are the
+// hashes of its address and their number only depends on its weight, so they
+// stay in place when other SubConns come and go.
+func newRing(subConns []*SubConn) ring {
+	var total float64
+	for _, sc := range subConns {
+		total += float64(addressWeight(sc.Address())) * defaultReplicas
+	}
+	scale := min(1, defaultMaxRingSize/total)
+	var r ring
+	for _, sc := range subConns {
+		addr := sc.Address()
+		// Every SubConn has at least one entry.
+		n := math.Ceil(float64(addressWeight(addr)) * defaultReplicas * scale)
+		for i := range int(n) {
+			r = append(r, ringEntry{
+				hash:    xxhash.Sum64String(addr.Addr + "_" + strconv.Itoa(i)),
+				subConn: sc,
+			})
+		}
+	}
+	slices.SortFunc(r, func(a, b ringEntry) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return r
+}
+
+// lookup returns the index of the entry owning the hash.
+func (r ring) lookup(hash uint64) int {
+	i, _ := slices.BinarySearchFunc(r, hash, func(e ringEntry, hash uint64) int {
+		return cmp.Compare(e.hash, hash)
+	})
+	// Past the last entry the ring wraps around.
+	return i % len(r)
+}
@@ -0,0 +1,116 @@
+package balancer
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/vimcoders/grpcx/resolver"
+
+	"github.com/vimcoders/grpcx/metadata"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// fakeSubConn returns a SubConn of addr which is not connected.
+func fakeSubConn(addr resolver.Address) *SubConn {
+	sc := &SubConn{}
+	sc.address.Store(&addr)
+	return sc
+}
+
+// owners returns the address owning each of n keys on the ring.
+func owners(r ring, n int) []string {
+	owners := make([]string, n)
+	for i := range owners {
+		owners[i] = r[r.lookup(xxhash.Sum64String("user-"+strconv.Itoa(i)))].subConn.Address().Addr
+	}
+	return owners
+}
+
+func TestRingMovesFewKeys(t *testing.T) {
+	const keys = 10000
+	subConns := []*SubConn{
+		fakeSubConn(resolver.Address{Addr: "10.0.0.1:8080"}),
+		fakeSubConn(resolver.Address{Addr: "10.0.0.2:8080"}),
+		fakeSubConn(resolver.Address{Addr: "10.0.0.3:8080"}),
+	}
+	before := owners(newRing(subConns), keys)
+	share := make(map[string]int)
+	for _, owner := range before {
+		share[owner]++
+	}
+	for addr, n := range share {
+		if math.Abs(float64(n)/keys-1.0/3) > 0.1 {
+			t.Fatalf("%v owns %d of %d keys", addr, n, keys)
+		}
+	}
+
+	// Only keys moving to the new backend move.
+	added := "10.0.0.4:8080"
+	after := owners(newRing(append(subConns, fakeSubConn(resolver.Address{Addr: added}))), keys)
+	var moved int
+	for i := range after {
+		if after[i] == before[i] {
+			continue
+		}
+		if after[i] != added {
+			t.Fatalf("key %d moved from %v to %v", i, before[i], after[i])
+		}
+		moved++
+	}
+	if math.Abs(float64(moved)/keys-1.0/4) > 0.1 {
+		t.Fatalf("%d of %d keys moved", moved, keys)
+	}
+}
+
+func TestRingWeights(t *testing.T) {
+	const keys = 10000
+	heavy := resolver.WithWeight(resolver.Address{Addr: "10.0.0.1:8080"}, 3)
+	r := newRing([]*SubConn{fakeSubConn(heavy), fakeSubConn(resolver.Address{Addr: "10.0.0.2:8080"})})
+	var n int
+	for _, owner := range owners(r, keys) {
+		if owner == heavy.Addr {
+			n++
+		}
+	}
+	if math.Abs(float64(n)/keys-3.0/4) > 0.1 {
+		t.Fatalf("%v of weight 3 owns %d of %d keys", heavy.Addr, n, keys)
+	}
+}
+
+func TestRingHashAffinity(t *testing.T) {
+	r := newPushResolver(newBackend(t), newBackend(t), newBackend(t))
+	rh, err := newRingHash(context.Background(), r, url.URL{}, dialAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+	pick := func(info PickInfo) string {
+		t.Helper()
+		res, err := rh.Pick(context.Background(), info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.RoundTripper.(*SubConn).Address().Addr
+	}
+	backends := make(map[string]bool)
+	for i := range 30 {
+		key := "user-" + strconv.Itoa(i)
+		backend := pick(PickInfo{HashKey: key})
+		for range 3 {
+			if got := pick(PickInfo{HashKey: key}); got != backend {
+				t.Fatalf("%v picked %v, then %v", key, backend, got)
+			}
+		}
+		if got := pick(PickInfo{Metadata: metadata.Pairs(HashKeyHeader, key)}); got != backend {
+			t.Fatalf("%v in metadata picked %v, want %v", key, got, backend)
+		}
+		backends[backend] = true
+	}
+	if len(backends) != 3 {
+		t.Fatalf("keys spread over %d backends, want 3", len(backends))
+	}
+}
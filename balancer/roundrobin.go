@@ -2,10 +2,8 @@ package balancer
 
 import (
 	"context"
-	"math"
 	"math/rand"
 	"net/url"
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -15,7 +13,7 @@ import (
 
 	"github.com/vimcoders/grpcx/resolver"
 
-	"github.com/vimcoders/grpcx/generated/api"
+	"google.golang.org/grpc/connectivity"
 )
 
 const (
@@ -27,158 +25,140 @@ const (
 	defaultKeepalive = time.Minute
 	// defaultKeepaliveTimeout is the default keepalive timeout of round robin balancer.
 	defaultKeepaliveTimeout = time.Second * 5
+	// defaultDrainTimeout is the time given to the calls in flight on a
+	// SubConn of a removed address before it is closed.
+	defaultDrainTimeout = time.Second * 30
 )
 
+func init() {
+	Register(NewRoundRobinBuilder(nil))
+}
+
 // rrBuilder is the builder of round robin balancer.
-type rrBuilder struct{}
+type rrBuilder struct {
+	outlierDetection *OutlierDetectionConfig
+}
+
+// NewRoundRobinBuilder returns a builder of round robin balancers ejecting
+// outliers as configured, nil disables the outlier detection. The default one
+// does not detect outliers, register one to opt in, e.g.
+//
+//	balancer.Register(balancer.NewRoundRobinBuilder(&balancer.DefaultOutlierDetectionConfig))
+func NewRoundRobinBuilder(outlierDetection *OutlierDetectionConfig) Builder {
+	if outlierDetection != nil {
+		c := *outlierDetection
+		outlierDetection = &c
+	}
+	return &rrBuilder{outlierDetection: outlierDetection}
+}
+
+// Name returns the name of the round robin balancer.
+func (b *rrBuilder) Name() string {
+	return RoundRobinName
+}
 
 // Build builds a round robin balancer.
 func (b *rrBuilder) Build(ctx context.Context, endpoint string, opts ...roundtrip.Option) (Picker, error) {
-	// Create a child context that can be canceled when the balancer is closed.
-	childCtx, cancel := context.WithCancel(ctx)
-	// Create a round robin balancer.
-	var x = RoundRobin{
-		dialContext: func(ctx context.Context) (roundtrip.RoundTripper, error) {
-			return roundtrip.DialContext(ctx, endpoint, opts...)
-		},
-		resolveContext: func(ctx context.Context) ([]resolver.Address, error) {
-			resolver := resolver.GetResolver("dns")
-			address, err := resolver.Resolve(url.URL{Scheme: "dns", Host: endpoint})
-			if err != nil {
-				return nil, err
-			}
-			return address, nil
-		},
-		cancelFunc: cancel,
-	}
-	// Resolve the endpoint to get the addresses.
-	address, err := x.resolveContext(ctx)
+	target := resolver.ParseTarget(endpoint)
+	r, err := resolver.Build(target.Scheme)
 	if err != nil {
 		return nil, err
 	}
-	// Dial to each address and create a round tripper for each.
-	for range address {
-		rt, err := x.dialContext(ctx)
-		if err != nil {
-			return nil, err
-		}
-		x.rts = append(x.rts, rt)
+	return newRoundRobin(ctx, r, target, dialer(opts...), b.outlierDetection)
+}
+
+// dialer returns a function dialing the address of a SubConn with the
+// round tripper options.
+func dialer(opts ...roundtrip.Option) func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error) {
+	return func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error) {
+		return roundtrip.DialContext(ctx, addr.Addr, opts...)
+	}
+}
+
+// newRoundRobin builds a round robin balancer over the addresses of the
+// target, it dials a SubConn for each of them. The SubConns follow the
+// addresses sent by the resolver watching the target. Outliers are ejected
+// unless outlierDetection is nil.
+func newRoundRobin(ctx context.Context, r resolver.Resolver, target url.URL, dialContext func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error), outlierDetection *OutlierDetectionConfig) (*RoundRobin, error) {
+	var x RoundRobin
+	if err := x.start(ctx, r, target, dialContext); err != nil {
+		return nil, err
 	}
 	// Randomly select the next round tripper to use.
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	next := uint32(rng.Intn(len(x.rts)))
+	next := uint32(rng.Intn(len(x.SubConns())))
 	x.next.Store(next)
-	go func() {
-		if err := x.Keepalive(childCtx); err != nil {
-			return
-		}
-	}()
+	if outlierDetection != nil {
+		x.outliers = newOutlierDetector(*outlierDetection)
+		var outlierCtx context.Context
+		outlierCtx, x.stopOutliers = context.WithCancel(ctx)
+		go x.outliers.run(outlierCtx, x.SubConns)
+	}
 	// Return the round robin balancer.
 	return &x, nil
 }
 
-// RoundRobin is a round robin balancer.
+// RoundRobin is a round robin balancer. It ejects the backends whose calls
+// fail, see OutlierDetectionConfig.
 type RoundRobin struct {
-	rts            []roundtrip.RoundTripper
-	next           atomic.Uint32
-	dialContext    func(ctx context.Context) (roundtrip.RoundTripper, error)
-	resolveContext func(ctx context.Context) ([]resolver.Address, error)
-	cancelFunc     context.CancelFunc
-	sync.RWMutex
+	subConnList
+	next         atomic.Uint32
+	outliers     *outlierDetector
+	stopOutliers context.CancelFunc
 }
 
-// Pick picks a round tripper from the round robin balancer.
-func (rr *RoundRobin) Pick(_ context.Context, _ PickInfo) (roundtrip.RoundTripper, error) {
+// Pick picks a round tripper from the round robin balancer. Round trippers
+// which are not Ready or whose backend is ejected are skipped. If none is
+// left, the next one which is not ejected is picked and the call fails or
+// waits for it to reconnect, or the next one if all are ejected.
+func (rr *RoundRobin) Pick(_ context.Context, _ PickInfo) (PickResult, error) {
 	rr.RLock()
 	defer rr.RUnlock()
-	rts := rr.rts
-	if len(rts) == 0 {
-		return nil, status.ResourceExhausted.Err()
+	subConns := rr.subConns
+	if len(subConns) == 0 {
+		return PickResult{}, status.ResourceExhausted.Err()
 	}
-	idx := rr.next.Add(defaultStep) % uint32(len(rts))
-	return rts[idx], nil
-}
-
-// DialContext dials a round robin balancer.
-func DialContext(ctx context.Context, endpoint string, opts ...roundtrip.Option) (Picker, error) {
-	var b rrBuilder
-	for range math.MaxInt8 {
-		p, err := b.Build(ctx, endpoint, opts...)
-		if err != nil {
+	idx := rr.next.Add(defaultStep) % uint32(len(subConns))
+	picked := subConns[idx]
+	for i := range uint32(len(subConns)) {
+		sc := subConns[(idx+i)%uint32(len(subConns))]
+		if sc.outlier.ejected.Load() {
 			continue
 		}
-		return p, nil
-	}
-	return nil, status.OutOfRange.Err()
-}
-
-// Keepalive keeps the round robin balancer alive.
-func (rr *RoundRobin) Keepalive(ctx context.Context) error {
-	ticker := time.NewTicker(defaultKeepalive)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return status.Canceled.Err()
-		case <-ticker.C:
-			_ = rr.keepalive(ctx)
+		if sc.GetState() == connectivity.Ready {
+			picked = sc
+			break
+		}
+		if picked.outlier.ejected.Load() {
+			picked = sc
 		}
 	}
+	return rr.result(picked), nil
 }
 
-// keepalive keeps the round robin balancer alive.
-func (rr *RoundRobin) keepalive(ctx context.Context) error {
-	// Create a timeout context for the keepalive.
-	timeoutCtx, cancel := context.WithTimeout(ctx, defaultKeepaliveTimeout)
-	defer cancel()
-
-	rr.Lock()
-	defer rr.Unlock()
-	// Resolve the endpoint to get the addresses.
-	ips, err := rr.resolveContext(timeoutCtx)
-	if err != nil {
-		return err
+// result returns the result of the pick of sc, whose calls are counted by the
+// outlier detection.
+func (rr *RoundRobin) result(sc *SubConn) PickResult {
+	if rr.outliers == nil {
+		return PickResult{RoundTripper: sc}
 	}
-	// Create a request to send to the round trippers.
-	req := &api.Request{}
-	var rts []roundtrip.RoundTripper
-	// Check the existing round trippers and remove any that are no longer valid.
-	for _, rt := range rr.rts {
-		if _, err := rt.RoundTrip(timeoutCtx, req); err != nil {
-			_ = rt.Close()
-			continue
-		}
-		if len(rts) >= len(ips) {
-			_ = rt.Close()
-			continue
-		}
-		rts = append(rts, rt)
-	}
-	// Create new round trippers for any new addresses.
-	for i := len(rts); i < len(ips); i++ {
-		rt, err := rr.dialContext(timeoutCtx)
-		if err != nil {
-			continue
-		}
-		rts = append(rts, rt)
+	return PickResult{
+		RoundTripper: sc,
+		Done: func(di DoneInfo) {
+			rr.outliers.done(sc, rr.SubConns, di.Err)
+		},
 	}
-	// Update the round tripper list.
-	rr.rts = rts
-	return nil
 }
 
-// Close closes the round robin balancer.
+// Close closes the round robin balancer and its SubConns.
 func (rr *RoundRobin) Close() error {
-	rr.Lock()
-	defer rr.Unlock()
-	// Cancel the context to stop the keepalive goroutine.
-	if rr.cancelFunc != nil {
-		rr.cancelFunc()
+	if rr.stopOutliers != nil {
+		rr.stopOutliers()
 	}
-	// 	Close all the round trippers.
-	rts := rr.rts
-	for i := range rts {
-		_ = rts[i].Close()
-	}
-	return nil
+	return rr.subConnList.Close()
+}
+
+// DialContext dials a round robin balancer.
+func DialContext(ctx context.Context, endpoint string, opts ...roundtrip.Option) (Picker, error) {
+	return Build(ctx, RoundRobinName, endpoint, opts...)
 }
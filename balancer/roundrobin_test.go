@@ -0,0 +1,230 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/resolver"
+
+	"github.com/vimcoders/grpcx/generated/api"
+)
+
+// backendHandler replies with the address of the backend serving the call,
+// after delay.
+type backendHandler struct {
+	api.UnimplementedEchoServiceServer
+	addr  string
+	delay time.Duration
+}
+
+func (h *backendHandler) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	time.Sleep(h.delay)
+	return &api.EchoResponse{Message: h.addr}, nil
+}
+
+// newBackend serves the echo service on a random local port and returns its address.
+func newBackend(t *testing.T) string {
+	t.Helper()
+	return newSlowBackend(t, 0)
+}
+
+// newSlowBackend serves the echo service replying after delay on a random
+// local port and returns its address. The options add e.g. other services.
+func newSlowBackend(t *testing.T, delay time.Duration, opts ...roundtrip.ServerOption) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	so := roundtrip.DefaultServerOptions
+	roundtrip.RegisterService(&api.EchoService_ServiceDesc, &backendHandler{addr: lis.Addr().String(), delay: delay})(&so)
+	for _, o := range opts {
+		o(&so)
+	}
+	go func() {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go so.Handle(context.Background(), c)
+		}
+	}()
+	return lis.Addr().String()
+}
+
+// pushResolver sends the addresses it is given to its watch.
+type pushResolver struct {
+	updates    chan []resolver.Address
+	resolveNow atomic.Int32
+}
+
+func newPushResolver(addrs ...string) *pushResolver {
+	r := &pushResolver{updates: make(chan []resolver.Address, 1)}
+	r.push(addrs...)
+	return r
+}
+
+func (r *pushResolver) push(addrs ...string) {
+	var address []resolver.Address
+	for _, addr := range addrs {
+		address = append(address, resolver.Address{Addr: addr})
+	}
+	r.updates <- address
+}
+
+func (r *pushResolver) Resolve(u url.URL) ([]resolver.Address, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *pushResolver) Watch(ctx context.Context, u url.URL) (<-chan []resolver.Address, error) {
+	return r.updates, nil
+}
+
+func (r *pushResolver) ResolveNow() {
+	r.resolveNow.Add(1)
+}
+
+func (r *pushResolver) Close() error {
+	return nil
+}
+
+func dialAddress(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error) {
+	return roundtrip.DialContext(ctx, addr.Addr)
+}
+
+func subConnAddrs(rr *RoundRobin) []string {
+	var addrs []string
+	for _, sc := range rr.SubConns() {
+		addrs = append(addrs, sc.Address().Addr)
+	}
+	return addrs
+}
+
+// pickBackends returns the backends which served n calls picked by rr.
+func pickBackends(t *testing.T, rr *RoundRobin, n int) []string {
+	t.Helper()
+	var backends []string
+	for range n {
+		res, err := rr.Pick(context.Background(), PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt := res.RoundTripper
+		resp, err := api.NewEchoServiceClient(rt).Echo(context.Background(), &api.EchoRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := resp.Message, rt.(*SubConn).Address().Addr; got != want {
+			t.Fatalf("call to %v served by %v", want, got)
+		}
+		if !slices.Contains(backends, resp.Message) {
+			backends = append(backends, resp.Message)
+		}
+	}
+	slices.Sort(backends)
+	return backends
+}
+
+func sorted(s ...string) []string {
+	slices.Sort(s)
+	return s
+}
+
+func TestRoundRobinSubConnPerAddress(t *testing.T) {
+	a, b, c := newBackend(t), newBackend(t), newBackend(t)
+	r := newPushResolver(a, b)
+	rr, err := newRoundRobin(context.Background(), r, url.URL{}, dialAddress, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	if got, want := subConnAddrs(rr), []string{a, b}; !slices.Equal(got, want) {
+		t.Fatalf("got SubConns %v, want %v", got, want)
+	}
+	if got, want := pickBackends(t, rr, 4), sorted(a, b); !slices.Equal(got, want) {
+		t.Fatalf("got backends %v, want %v", got, want)
+	}
+
+	kept := rr.SubConns()[1]
+	r.push(b, c)
+	for deadline := time.Now().Add(5 * time.Second); !slices.Equal(subConnAddrs(rr), []string{b, c}); {
+		if time.Now().After(deadline) {
+			t.Fatalf("got SubConns %v, want %v", subConnAddrs(rr), []string{b, c})
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rr.SubConns()[0] != kept {
+		t.Fatalf("SubConn of %v was redialed", b)
+	}
+	if got, want := pickBackends(t, rr, 4), sorted(b, c); !slices.Equal(got, want) {
+		t.Fatalf("got backends %v, want %v", got, want)
+	}
+
+	// A dead backend is redialed and the resolver asked to resolve again.
+	_ = rr.SubConns()[1].Close()
+	if err := rr.keepalive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.resolveNow.Load(); got != 1 {
+		t.Fatalf("got %d ResolveNow calls, want 1", got)
+	}
+	if got, want := pickBackends(t, rr, 4), sorted(b, c); !slices.Equal(got, want) {
+		t.Fatalf("got backends %v, want %v", got, want)
+	}
+}
+
+func TestRoundRobinPickWhileDialing(t *testing.T) {
+	a, b := newBackend(t), newBackend(t)
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	dial := func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error) {
+		if addr.Addr == b {
+			once.Do(func() { close(dialing) })
+			<-release
+		}
+		return dialAddress(ctx, addr)
+	}
+	r := newPushResolver(a)
+	rr, err := newRoundRobin(context.Background(), r, url.URL{}, dial, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	unblock := sync.OnceFunc(func() { close(release) })
+	defer unblock()
+	r.push(a, b)
+	<-dialing
+	// The SubConn of b is being dialed, a is still picked meanwhile.
+	picked := make(chan error, 1)
+	go func() {
+		_, err := rr.Pick(context.Background(), PickInfo{})
+		picked <- err
+	}()
+	select {
+	case err := <-picked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pick blocked while dialing")
+	}
+	unblock()
+	for deadline := time.Now().Add(5 * time.Second); !slices.Equal(subConnAddrs(rr), []string{a, b}); {
+		if time.Now().After(deadline) {
+			t.Fatalf("got SubConns %v, want %v", subConnAddrs(rr), []string{a, b})
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,303 @@
+package balancer
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/resolver"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+// SubConn is a connection of a balancer to a single backend.
+type SubConn struct {
+	roundtrip.RoundTripper
+	// address is replaced when the attributes of the address change.
+	address atomic.Pointer[resolver.Address]
+	// load is the load of the calls picked by load aware balancers.
+	load load
+	// outlier counts the calls for the outlier detection.
+	outlier outlierStats
+	// notServing is set while the backend reports it is not serving,
+	// stopHealth stops watching it.
+	notServing atomic.Bool
+	stopHealth context.CancelFunc
+}
+
+// Address returns the address of the backend the SubConn is connected to.
+func (sc *SubConn) Address() resolver.Address {
+	return *sc.address.Load()
+}
+
+// String returns the address of the backend and the state of the SubConn.
+func (sc *SubConn) String() string {
+	return sc.Address().Addr + " (" + sc.GetState().String() + ")"
+}
+
+// alive reports whether the SubConn can still be used. SubConns which lost
+// their connection redial by themselves, those which are Ready are checked
+// with an empty request.
+func (sc *SubConn) alive(ctx context.Context, req *api.Request) bool {
+	switch sc.GetState() {
+	case connectivity.Shutdown:
+		return false
+	case connectivity.Ready:
+		_, err := sc.RoundTrip(ctx, req)
+		return err == nil
+	default:
+		return true
+	}
+}
+
+// drain closes the SubConn once the calls in flight on it had time to
+// finish, it is no longer picked.
+func (sc *SubConn) drain() {
+	time.AfterFunc(defaultDrainTimeout, func() {
+		_ = sc.Close()
+	})
+}
+
+// readySubConns returns the SubConns which are Ready, or all of them if none
+// is.
+func readySubConns(subConns []*SubConn) []*SubConn {
+	var ready []*SubConn
+	for _, sc := range subConns {
+		if sc.GetState() == connectivity.Ready {
+			ready = append(ready, sc)
+		}
+	}
+	if len(ready) == 0 {
+		return subConns
+	}
+	return ready
+}
+
+// addressWeight returns the weight of an address, addresses without a weight
+// or with a weight of zero weigh 1.
+func addressWeight(addr resolver.Address) uint32 {
+	if weight, ok := resolver.Weight(addr); ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// subConnList keeps a SubConn for each address of a target, following the
+// addresses sent by the resolver watching it. Balancers embed it and pick
+// among its SubConns.
+type subConnList struct {
+	subConns    []*SubConn
+	address     []resolver.Address
+	dialContext func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error)
+	resolver    resolver.Resolver
+	cancelFunc  context.CancelFunc
+	// updated, if set, is called with the lock held once the SubConns
+	// changed, e.g. to rebuild the state a balancer picks from.
+	updated func(subConns []*SubConn)
+	// update serializes the updates of the SubConns. They probe and dial
+	// without the lock, which is only held to swap the SubConns in, so that
+	// picks are not blocked meanwhile.
+	update sync.Mutex
+	closed bool
+	sync.RWMutex
+}
+
+// start watches the target and dials a SubConn for each of its first
+// addresses, it fails if none could be dialed. The SubConns are then kept
+// alive and follow the addresses until the list is closed. Only the subset
+// set on ctx with WithSubset is kept, if any.
+func (l *subConnList) start(ctx context.Context, r resolver.Resolver, target url.URL, dialContext func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error)) error {
+	if s, ok := ctx.Value(subsetKey{}).(subset); ok {
+		r = resolver.Subset(r, s.clientID, s.size)
+	}
+	// Create a child context that can be canceled when the balancer is closed.
+	childCtx, cancel := context.WithCancel(ctx)
+	l.dialContext = dialContext
+	l.resolver = r
+	l.cancelFunc = cancel
+	// Watch the target, the first addresses are sent at once.
+	updates, err := r.Watch(childCtx, target)
+	if err != nil {
+		cancel()
+		_ = r.Close()
+		return err
+	}
+	var address []resolver.Address
+	select {
+	case address = <-updates:
+	case <-ctx.Done():
+		cancel()
+		_ = r.Close()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	// Dial each address and create a SubConn for each.
+	l.updateSubConns(ctx, address)
+	if len(l.subConns) == 0 {
+		cancel()
+		_ = r.Close()
+		return status.Unavailable.Err()
+	}
+	go l.watch(childCtx, updates)
+	go func() {
+		if err := l.Keepalive(childCtx); err != nil {
+			return
+		}
+	}()
+	return nil
+}
+
+// watch updates the SubConns to the addresses sent by the resolver until
+// the channel is closed.
+func (l *subConnList) watch(ctx context.Context, updates <-chan []resolver.Address) {
+	for address := range updates {
+		timeoutCtx, cancel := context.WithTimeout(ctx, defaultKeepaliveTimeout)
+		l.updateSubConns(timeoutCtx, address)
+		cancel()
+	}
+}
+
+// dial creates a SubConn for the given address.
+func (l *subConnList) dial(ctx context.Context, addr resolver.Address) (*SubConn, error) {
+	rt, err := l.dialContext(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	sc := &SubConn{RoundTripper: rt}
+	sc.address.Store(&addr)
+	sc.watchHealth()
+	return sc, nil
+}
+
+// SubConns returns the SubConns of the balancer, one per resolved address.
+func (l *subConnList) SubConns() []*SubConn {
+	l.RLock()
+	defer l.RUnlock()
+	return slices.Clone(l.subConns)
+}
+
+// Keepalive keeps the SubConns of the balancer alive.
+func (l *subConnList) Keepalive(ctx context.Context) error {
+	ticker := time.NewTicker(defaultKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return status.Canceled.Err()
+		case <-ticker.C:
+			_ = l.keepalive(ctx)
+		}
+	}
+}
+
+// keepalive checks the SubConns of the current addresses, dead ones are
+// redialed. The resolver is asked to resolve again if one was dead, the
+// backend may have moved.
+func (l *subConnList) keepalive(ctx context.Context) error {
+	// Create a timeout context for the keepalive.
+	timeoutCtx, cancel := context.WithTimeout(ctx, defaultKeepaliveTimeout)
+	defer cancel()
+	l.update.Lock()
+	defer l.update.Unlock()
+	if l.updateSubConnsLocked(timeoutCtx, l.address) {
+		l.resolver.ResolveNow()
+	}
+	return nil
+}
+
+// updateSubConns diffs the SubConns against the given addresses. SubConns of
+// new addresses are dialed, those of removed addresses are drained.
+func (l *subConnList) updateSubConns(ctx context.Context, address []resolver.Address) {
+	l.update.Lock()
+	defer l.update.Unlock()
+	l.updateSubConnsLocked(ctx, address)
+}
+
+// updateSubConnsLocked diffs the SubConns against the given addresses. Every
+// address keeps its SubConn as long as it is alive, the attributes of the
+// address are updated. It reports whether a SubConn was dead.
+//
+// It is called with l.update held. The SubConns are probed and dialed
+// without the lock, it is only held to swap them in.
+func (l *subConnList) updateSubConnsLocked(ctx context.Context, address []resolver.Address) bool {
+	l.RLock()
+	current := make(map[string]*SubConn, len(l.subConns))
+	for _, sc := range l.subConns {
+		current[sc.Address().Addr] = sc
+	}
+	l.RUnlock()
+	// Create a request to send to the round trippers.
+	req := &api.Request{}
+	var subConns, dialed, closing []*SubConn
+	var dead bool
+	for _, addr := range address {
+		sc, ok := current[addr.Addr]
+		if ok {
+			delete(current, addr.Addr)
+			if sc.alive(ctx, req) {
+				sc.address.Store(&addr)
+				subConns = append(subConns, sc)
+				continue
+			}
+			dead = true
+			closing = append(closing, sc)
+		}
+		sc, err := l.dial(ctx, addr)
+		if err != nil {
+			dead = true
+			continue
+		}
+		subConns = append(subConns, sc)
+		dialed = append(dialed, sc)
+	}
+	l.Lock()
+	if l.closed {
+		// The list was closed meanwhile, it closed the SubConns it had.
+		l.Unlock()
+		for _, sc := range dialed {
+			_ = sc.Close()
+		}
+		return dead
+	}
+	// Update the SubConn list.
+	l.address = address
+	l.subConns = subConns
+	if l.updated != nil {
+		l.updated(subConns)
+	}
+	l.Unlock()
+	// The dead SubConns are no longer picked, the remaining ones target
+	// addresses which are gone.
+	for _, sc := range closing {
+		_ = sc.Close()
+	}
+	for _, sc := range current {
+		sc.drain()
+	}
+	return dead
+}
+
+// Close closes the balancer and its SubConns.
+func (l *subConnList) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	l.closed = true
+	// Cancel the context to stop the keepalive and watch goroutines.
+	if l.cancelFunc != nil {
+		l.cancelFunc()
+	}
+	_ = l.resolver.Close()
+	// Close all the SubConns.
+	for _, sc := range l.subConns {
+		_ = sc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package balancer
+
+import (
+	"container/heap"
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/resolver"
+
+	"github.com/vimcoders/grpcx/orca"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	// WeightedRoundRobinName is the name of the weighted round robin balancer.
+	WeightedRoundRobinName = "weighted_round_robin"
+	// defaultWeightUpdatePeriod is the interval between two updates of the
+	// weights of the weighted round robin balancer.
+	defaultWeightUpdatePeriod = time.Second
+	// defaultWeightExpiration is the time after which the load reported by
+	// a backend is no longer used.
+	defaultWeightExpiration = time.Minute * 3
+	// defaultErrorUtilizationPenalty is the utilization added per error per
+	// query, backends failing their calls weigh less.
+	defaultErrorUtilizationPenalty = 1.0
+)
+
+func init() {
+	Register(&wrrBuilder{})
+}
+
+// wrrBuilder is the builder of the weighted round robin balancer.
+type wrrBuilder struct{}
+
+// Name returns the name of the weighted round robin balancer.
+func (b *wrrBuilder) Name() string {
+	return WeightedRoundRobinName
+}
+
+// Build builds a weighted round robin balancer.
+func (b *wrrBuilder) Build(ctx context.Context, endpoint string, opts ...roundtrip.Option) (Picker, error) {
+	target := resolver.ParseTarget(endpoint)
+	r, err := resolver.Build(target.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return newWeightedRoundRobin(ctx, r, target, dialer(opts...))
+}
+
+// newWeightedRoundRobin builds a weighted round robin balancer over the
+// addresses of the target, it dials a SubConn for each of them.
+func newWeightedRoundRobin(ctx context.Context, r resolver.Resolver, target url.URL, dialContext func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error)) (*WeightedRoundRobin, error) {
+	x := &WeightedRoundRobin{}
+	x.updated = func([]*SubConn) {
+		x.stale.Store(true)
+	}
+	if err := x.start(ctx, r, target, dialContext); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// WeightedRoundRobin is a weighted round robin balancer: each backend gets a
+// share of the calls proportional to its weight, scheduled earliest deadline
+// first.
+//
+// The weight of a backend is derived from the load it reports, see package
+// orca: its queries per second divided by its utilization, penalized by its
+// errors. Backends which did not report their load recently weigh the mean
+// weight of the others. As long as no backend reports its load, the weights of
+// the addresses are used, see resolver.Weight.
+type WeightedRoundRobin struct {
+	subConnList
+	// stale is set once the SubConns changed.
+	stale atomic.Bool
+	// mu guards the scheduler, which is rebuilt with the current weights
+	// every second. The schedule goes on across rebuilds.
+	mu        sync.Mutex
+	scheduler edfScheduler
+	built     time.Time
+}
+
+// Pick picks the round tripper whose deadline is the earliest. Round
+// trippers which are not Ready are skipped, as many as there are round
+// trippers at most.
+func (w *WeightedRoundRobin) Pick(_ context.Context, _ PickInfo) (PickResult, error) {
+	w.RLock()
+	defer w.RUnlock()
+	if len(w.subConns) == 0 {
+		return PickResult{}, status.ResourceExhausted.Err()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if now := time.Now(); w.stale.Swap(false) || now.Sub(w.built) >= defaultWeightUpdatePeriod {
+		w.scheduler = newEDFScheduler(w.scheduler, w.subConns, subConnWeights(w.subConns, now))
+		w.built = now
+	}
+	sc := w.scheduler.next()
+	for i := 1; i < len(w.scheduler) && sc.GetState() != connectivity.Ready; i++ {
+		sc = w.scheduler.next()
+	}
+	return PickResult{
+		RoundTripper: sc,
+		Done: func(di DoneInfo) {
+			if di.ServerLoad == nil {
+				return
+			}
+			if weight := serverWeight(di.ServerLoad); weight > 0 {
+				sc.load.setServerWeight(weight)
+			}
+		},
+	}, nil
+}
+
+// serverWeight returns the weight of a backend derived from its load report,
+// zero if it is not enough to weigh the backend.
+func serverWeight(r *orca.LoadReport) float64 {
+	utilization := r.ApplicationUtilization
+	if utilization <= 0 {
+		utilization = r.CPUUtilization
+	}
+	if r.QPS <= 0 || utilization <= 0 {
+		return 0
+	}
+	return r.QPS / (utilization + r.EPS/r.QPS*defaultErrorUtilizationPenalty)
+}
+
+// subConnWeights returns the weights of the SubConns at now.
+func subConnWeights(subConns []*SubConn, now time.Time) []float64 {
+	weights := make([]float64, len(subConns))
+	var sum float64
+	var reported int
+	for i, sc := range subConns {
+		if weight, ok := sc.load.serverWeightSince(now.Add(-defaultWeightExpiration)); ok {
+			weights[i] = weight
+			sum += weight
+			reported++
+		}
+	}
+	if reported == 0 {
+		for i, sc := range subConns {
+			weights[i] = float64(addressWeight(sc.Address()))
+		}
+		return weights
+	}
+	for i := range weights {
+		if weights[i] == 0 {
+			weights[i] = sum / float64(reported)
+		}
+	}
+	return weights
+}
+
+// edfEntry is a SubConn scheduled by an edfScheduler.
+type edfEntry struct {
+	deadline float64
+	weight   float64
+	index    int
+	subConn  *SubConn
+}
+
+// edfScheduler is an earliest deadline first scheduler, a min-heap of
+// entries ordered by deadline. The deadline of an entry picked moves by the
+// inverse of its weight, so entries are picked in proportion to their weight.
+type edfScheduler []*edfEntry
+
+// newEDFScheduler builds the scheduler of the SubConns with their weights.
+// The SubConns scheduled by prev keep the part of their period left until
+// their deadline, so that a schedule rebuilt before every pick still goes
+// through all the SubConns.
+func newEDFScheduler(prev edfScheduler, subConns []*SubConn, weights []float64) edfScheduler {
+	left := make(map[*SubConn]float64, len(prev))
+	for _, e := range prev {
+		left[e.subConn] = (e.deadline - prev[0].deadline) * e.weight
+	}
+	s := make(edfScheduler, len(subConns))
+	for i, sc := range subConns {
+		period, ok := left[sc]
+		if !ok {
+			period = 1
+		}
+		s[i] = &edfEntry{deadline: period / weights[i], weight: weights[i], index: i, subConn: sc}
+	}
+	heap.Init(&s)
+	return s
+}
+
+// next returns the SubConn whose deadline is the earliest and moves its
+// deadline.
+func (s edfScheduler) next() *SubConn {
+	e := s[0]
+	e.deadline += 1 / e.weight
+	heap.Fix(&s, 0)
+	return e.subConn
+}
+
+func (s edfScheduler) Len() int { return len(s) }
+
+// Less orders the entries by deadline, then by index so that the schedule
+// is deterministic.
+func (s edfScheduler) Less(i, j int) bool {
+	if s[i].deadline != s[j].deadline {
+		return s[i].deadline < s[j].deadline
+	}
+	return s[i].index < s[j].index
+}
+
+func (s edfScheduler) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s *edfScheduler) Push(x any) { *s = append(*s, x.(*edfEntry)) }
+
+func (s *edfScheduler) Pop() any {
+	old := *s
+	e := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return e
+}
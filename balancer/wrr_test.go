@@ -0,0 +1,123 @@
+package balancer
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/vimcoders/grpcx/resolver"
+
+	"github.com/vimcoders/grpcx/orca"
+)
+
+func TestEDFScheduler(t *testing.T) {
+	subConns := []*SubConn{
+		fakeSubConn(resolver.Address{Addr: "a"}),
+		fakeSubConn(resolver.Address{Addr: "b"}),
+		fakeSubConn(resolver.Address{Addr: "c"}),
+	}
+	s := newEDFScheduler(nil, subConns, []float64{1, 2, 3})
+	picked := make(map[string]int)
+	for range 600 {
+		picked[s.next().Address().Addr]++
+	}
+	if picked["a"] != 100 || picked["b"] != 200 || picked["c"] != 300 {
+		t.Fatalf("got picks %v, want 100, 200 and 300", picked)
+	}
+}
+
+// TestEDFSchedulerRebuilt rebuilds the scheduler before every pick, as the
+// balancer does when its SubConns change or the weights are updated.
+func TestEDFSchedulerRebuilt(t *testing.T) {
+	subConns := []*SubConn{
+		fakeSubConn(resolver.Address{Addr: "a"}),
+		fakeSubConn(resolver.Address{Addr: "b"}),
+		fakeSubConn(resolver.Address{Addr: "c"}),
+	}
+	var s edfScheduler
+	picked := make(map[string]int)
+	for range 600 {
+		s = newEDFScheduler(s, subConns, []float64{1, 2, 3})
+		picked[s.next().Address().Addr]++
+	}
+	if picked["a"] != 100 || picked["b"] != 200 || picked["c"] != 300 {
+		t.Fatalf("got picks %v, want 100, 200 and 300", picked)
+	}
+	// A SubConn added is scheduled along the others.
+	subConns = append(subConns, fakeSubConn(resolver.Address{Addr: "d"}))
+	clear(picked)
+	for range 900 {
+		s = newEDFScheduler(s, subConns, []float64{1, 2, 3, 3})
+		picked[s.next().Address().Addr]++
+	}
+	for addr, want := range map[string]int{"a": 100, "b": 200, "c": 300, "d": 300} {
+		if got := picked[addr]; got < want-1 || got > want+1 {
+			t.Fatalf("got picks %v, want 100, 200, 300 and 300", picked)
+		}
+	}
+}
+
+func TestServerWeight(t *testing.T) {
+	tests := []struct {
+		report orca.LoadReport
+		want   float64
+	}{
+		{orca.LoadReport{QPS: 100, CPUUtilization: 0.5}, 200},
+		{orca.LoadReport{QPS: 100, CPUUtilization: 0.5, ApplicationUtilization: 0.25}, 400},
+		{orca.LoadReport{QPS: 100, CPUUtilization: 0.5, EPS: 50}, 100},
+		{orca.LoadReport{CPUUtilization: 0.5}, 0},
+		{orca.LoadReport{QPS: 100}, 0},
+	}
+	for _, tt := range tests {
+		if got := serverWeight(&tt.report); got != tt.want {
+			t.Errorf("weight of %v got %v, want %v", tt.report, got, tt.want)
+		}
+	}
+}
+
+// pickShares returns the number of n calls picked by w for each backend,
+// the load reported by a backend is the one of its address in reports.
+func pickShares(t *testing.T, w *WeightedRoundRobin, n int, reports map[string]*orca.LoadReport) map[string]int {
+	t.Helper()
+	picked := make(map[string]int)
+	for range n {
+		res, err := w.Pick(context.Background(), PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := res.RoundTripper.(*SubConn).Address().Addr
+		picked[addr]++
+		res.Done(DoneInfo{ServerLoad: reports[addr]})
+	}
+	return picked
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	a, b := newBackend(t), newBackend(t)
+	r := &pushResolver{updates: make(chan []resolver.Address, 1)}
+	r.updates <- []resolver.Address{
+		resolver.WithWeight(resolver.Address{Addr: a}, 1),
+		resolver.WithWeight(resolver.Address{Addr: b}, 3),
+	}
+	w, err := newWeightedRoundRobin(context.Background(), r, url.URL{}, dialAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Without load reports the weights of the addresses are used.
+	if got := pickShares(t, w, 400, nil); got[a] != 100 || got[b] != 300 {
+		t.Fatalf("got picks %v, want 100 and 300", got)
+	}
+
+	// The load reported takes over once the weights are updated.
+	reports := map[string]*orca.LoadReport{
+		a: {QPS: 100, CPUUtilization: 0.25},
+		b: {QPS: 100, CPUUtilization: 0.5},
+	}
+	pickShares(t, w, 4, reports)
+	w.stale.Store(true)
+	if got := pickShares(t, w, 300, reports); got[a] != 200 || got[b] != 100 {
+		t.Fatalf("got picks %v, want 200 and 100", got)
+	}
+}
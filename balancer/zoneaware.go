@@ -0,0 +1,198 @@
+package balancer
+
+import (
+	"cmp"
+	"context"
+	"math/rand"
+	"net/url"
+	"os"
+	"slices"
+	"sync/atomic"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/resolver"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	// ZoneAwareName is the name of the zone aware balancer.
+	ZoneAwareName = "zone_aware"
+	// ZoneEnv is the environment variable holding the zone of the client
+	// when ZoneAwareConfig.Zone is empty.
+	ZoneEnv = "GRPCX_ZONE"
+	// defaultSpilloverThreshold is the default share of the backends of the
+	// zone of the client which must be Ready for all calls to stay in zone.
+	defaultSpilloverThreshold = 0.7
+)
+
+func init() {
+	Register(NewZoneAwareBuilder(ZoneAwareConfig{}))
+}
+
+// ZoneAwareConfig configures the zone aware balancer.
+type ZoneAwareConfig struct {
+	// Zone is the zone of the client, the value of ZoneEnv if empty.
+	Zone string
+	// SpilloverThreshold is the share of the backends of the zone of the
+	// client which must be Ready for all calls to stay in zone, 0.7 if zero.
+	// Below, calls spill over to the other zones in proportion.
+	SpilloverThreshold float64
+}
+
+// zoneAwareBuilder is the builder of the zone aware balancer.
+type zoneAwareBuilder struct {
+	config ZoneAwareConfig
+}
+
+// NewZoneAwareBuilder returns a builder of zone aware balancers with the
+// config. The default one takes the zone of the client from ZoneEnv.
+func NewZoneAwareBuilder(config ZoneAwareConfig) Builder {
+	return &zoneAwareBuilder{config: config}
+}
+
+// Name returns the name of the zone aware balancer.
+func (b *zoneAwareBuilder) Name() string {
+	return ZoneAwareName
+}
+
+// Build builds a zone aware balancer.
+func (b *zoneAwareBuilder) Build(ctx context.Context, endpoint string, opts ...roundtrip.Option) (Picker, error) {
+	target := resolver.ParseTarget(endpoint)
+	r, err := resolver.Build(target.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return newZoneAware(ctx, r, target, dialer(opts...), b.config)
+}
+
+// newZoneAware builds a zone aware balancer over the addresses of the
+// target, it dials a SubConn for each of them.
+func newZoneAware(ctx context.Context, r resolver.Resolver, target url.URL, dialContext func(ctx context.Context, addr resolver.Address) (roundtrip.RoundTripper, error), config ZoneAwareConfig) (*ZoneAware, error) {
+	x := &ZoneAware{
+		zone:      config.Zone,
+		threshold: config.SpilloverThreshold,
+	}
+	if x.zone == "" {
+		x.zone = os.Getenv(ZoneEnv)
+	}
+	if x.threshold <= 0 {
+		x.threshold = defaultSpilloverThreshold
+	}
+	x.updated = func(subConns []*SubConn) {
+		x.tiers = newZoneTiers(subConns, x.zone)
+	}
+	if err := x.start(ctx, r, target, dialContext); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// ZoneAware is a balancer keeping calls in the zone of the client, see
+// resolver.Zone.
+//
+// Backends are grouped in tiers by the priority of their address, see
+// resolver.Priority: calls go to the tier of the lowest priority with a
+// Ready backend, fallback tiers only take calls once the tiers before have
+// none. Within a tier, the backends of the zone of the client, or hinted to
+// serve it, take all the calls while at least SpilloverThreshold of them are
+// Ready. Below, the other zones take a share of the calls growing as the
+// local backends go down. Backends are picked round robin.
+type ZoneAware struct {
+	subConnList
+	zone      string
+	threshold float64
+	tiers     []zoneTier
+	next      atomic.Uint32
+}
+
+// zoneTier holds the SubConns of the addresses of a priority.
+type zoneTier struct {
+	priority uint32
+	// local are the SubConns of the zone of the client, remote those of
+	// the other zones.
+	local  []*SubConn
+	remote []*SubConn
+}
+
+// newZoneTiers groups the SubConns by priority, then by zone.
+func newZoneTiers(subConns []*SubConn, zone string) []zoneTier {
+	var tiers []zoneTier
+	for _, sc := range subConns {
+		addr := sc.Address()
+		priority, _ := resolver.Priority(addr)
+		i := slices.IndexFunc(tiers, func(t zoneTier) bool { return t.priority == priority })
+		if i < 0 {
+			tiers = append(tiers, zoneTier{priority: priority})
+			i = len(tiers) - 1
+		}
+		if zone != "" && (resolver.Zone(addr) == zone || slices.Contains(resolver.ZoneHints(addr), zone)) {
+			tiers[i].local = append(tiers[i].local, sc)
+		} else {
+			tiers[i].remote = append(tiers[i].remote, sc)
+		}
+	}
+	slices.SortFunc(tiers, func(a, b zoneTier) int {
+		return cmp.Compare(a.priority, b.priority)
+	})
+	return tiers
+}
+
+// Pick picks a round tripper of the zone of the client unless too few of
+// them are Ready. If no round tripper is Ready, one of the first tier is
+// picked and the call fails or waits for it to reconnect.
+func (z *ZoneAware) Pick(_ context.Context, _ PickInfo) (PickResult, error) {
+	z.RLock()
+	defer z.RUnlock()
+	if len(z.tiers) == 0 {
+		return PickResult{}, status.ResourceExhausted.Err()
+	}
+	next := z.next.Add(defaultStep)
+	for _, tier := range z.tiers {
+		localReady, remoteReady := countReady(tier.local), countReady(tier.remote)
+		if localReady+remoteReady == 0 {
+			continue
+		}
+		if localReady > 0 && (remoteReady == 0 || rand.Float64() < localShare(localReady, len(tier.local), z.threshold)) {
+			return PickResult{RoundTripper: pickReady(tier.local, next)}, nil
+		}
+		return PickResult{RoundTripper: pickReady(tier.remote, next)}, nil
+	}
+	subConns := z.tiers[0].local
+	if len(subConns) == 0 {
+		subConns = z.tiers[0].remote
+	}
+	return PickResult{RoundTripper: subConns[next%uint32(len(subConns))]}, nil
+}
+
+// localShare returns the share of the calls staying in zone when ready of
+// the total local backends are Ready.
+func localShare(ready, total int, threshold float64) float64 {
+	return min(1, float64(ready)/(float64(total)*threshold))
+}
+
+// countReady returns the number of SubConns which are Ready.
+func countReady(subConns []*SubConn) int {
+	var n int
+	for _, sc := range subConns {
+		if sc.GetState() == connectivity.Ready {
+			n++
+		}
+	}
+	return n
+}
+
+// pickReady picks the first SubConn which is Ready from the next one on, or
+// the next one if none is.
+func pickReady(subConns []*SubConn, next uint32) *SubConn {
+	n := uint32(len(subConns))
+	for i := range n {
+		if sc := subConns[(next+i)%n]; sc.GetState() == connectivity.Ready {
+			return sc
+		}
+	}
+	return subConns[next%n]
+}
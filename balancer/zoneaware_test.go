@@ -0,0 +1,92 @@
+package balancer
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/vimcoders/grpcx/resolver"
+)
+
+// zoneAddress returns the address of a backend in the zone with the priority.
+func zoneAddress(addr, zone string, priority uint32) resolver.Address {
+	return resolver.WithPriority(resolver.WithZone(resolver.Address{Addr: addr}, zone), priority)
+}
+
+// pickCounts returns the number of n calls picked by z for each backend.
+func pickCounts(t *testing.T, z *ZoneAware, n int) map[string]int {
+	t.Helper()
+	picked := make(map[string]int)
+	for range n {
+		res, err := z.Pick(context.Background(), PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		picked[res.RoundTripper.(*SubConn).Address().Addr]++
+	}
+	return picked
+}
+
+// closeSubConn closes the SubConn of addr, it is no longer Ready.
+func closeSubConn(t *testing.T, z *ZoneAware, addr string) {
+	t.Helper()
+	for _, sc := range z.SubConns() {
+		if sc.Address().Addr == addr {
+			_ = sc.Close()
+			return
+		}
+	}
+	t.Fatalf("no SubConn of %v", addr)
+}
+
+func TestZoneAware(t *testing.T) {
+	localA, localB, remote := newBackend(t), newBackend(t), newBackend(t)
+	fallback := newBackend(t)
+	r := &pushResolver{updates: make(chan []resolver.Address, 1)}
+	r.updates <- []resolver.Address{
+		zoneAddress(localA, "zone-a", 0),
+		zoneAddress(localB, "zone-a", 0),
+		zoneAddress(remote, "zone-b", 0),
+		zoneAddress(fallback, "zone-a", 1),
+	}
+	z, err := newZoneAware(context.Background(), r, url.URL{}, dialAddress, ZoneAwareConfig{Zone: "zone-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	// The calls stay in zone while enough local backends are Ready.
+	if got := pickCounts(t, z, 100); got[localA] != 50 || got[localB] != 50 {
+		t.Fatalf("got picks %v, want all in zone-a", got)
+	}
+
+	// Half the local backends are down, below the threshold of 70% about
+	// 1 - 0.5/0.7 of the calls spill over.
+	closeSubConn(t, z, localA)
+	got := pickCounts(t, z, 1000)
+	if got[localA] != 0 || got[remote] < 150 || got[remote] > 450 || got[localB]+got[remote] != 1000 {
+		t.Fatalf("got picks %v, want about 714 in zone-a and 286 in zone-b", got)
+	}
+
+	// The fallback tier takes the calls once the first one has none Ready.
+	closeSubConn(t, z, localB)
+	closeSubConn(t, z, remote)
+	if got := pickCounts(t, z, 10); got[fallback] != 10 {
+		t.Fatalf("got picks %v, want all to the fallback", got)
+	}
+}
+
+func TestZoneAwareWithoutZone(t *testing.T) {
+	a, b := newBackend(t), newBackend(t)
+	r := &pushResolver{updates: make(chan []resolver.Address, 1)}
+	r.updates <- []resolver.Address{zoneAddress(a, "zone-a", 0), zoneAddress(b, "zone-b", 0)}
+	t.Setenv(ZoneEnv, "")
+	z, err := newZoneAware(context.Background(), r, url.URL{}, dialAddress, ZoneAwareConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if got := pickCounts(t, z, 100); got[a] != 50 || got[b] != 50 {
+		t.Fatalf("got picks %v, want them spread evenly", got)
+	}
+}
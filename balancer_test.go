@@ -0,0 +1,278 @@
+package grpcx_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx/balancer"
+
+	"github.com/vimcoders/grpcx/orca"
+
+	"github.com/vimcoders/grpcx"
+
+	"google.golang.org/grpc"
+)
+
+func init() {
+	balancer.Register(countingBuilder{})
+}
+
+// countingBuilder builds round robin balancers, counting how many it built.
+type countingBuilder struct{}
+
+var countingBuilds atomic.Int32
+
+func (countingBuilder) Build(ctx context.Context, endpoint string, opts ...roundtrip.Option) (balancer.Picker, error) {
+	countingBuilds.Add(1)
+	return balancer.Get(balancer.RoundRobinName).Build(ctx, endpoint, opts...)
+}
+
+func (countingBuilder) Name() string {
+	return "counting"
+}
+
+func TestBalancerSelection(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+	})
+	tests := []struct {
+		name string
+		opts []grpcx.Option
+		want int32
+	}{
+		{name: "default", want: 0},
+		{name: "name", opts: []grpcx.Option{grpcx.WithBalancerName("counting")}, want: 1},
+		{name: "service config", opts: []grpcx.Option{grpcx.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"counting": {}}]}`)}, want: 1},
+		{name: "name over service config", opts: []grpcx.Option{
+			grpcx.WithBalancerName(balancer.RoundRobinName),
+			grpcx.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"counting": {}}]}`),
+		}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := countingBuilds.Load()
+			c, err := grpcx.Dial(addr, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if _, err := api.NewEchoServiceClient(c).Echo(context.Background(), &api.EchoRequest{Message: "hello"}); err != nil {
+				t.Fatal(err)
+			}
+			if got := countingBuilds.Load() - before; got != tt.want {
+				t.Fatalf("got %d builds, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := grpcx.Dial(addr, grpcx.WithBalancerName("unknown")); err == nil {
+		t.Fatal("dialing with an unknown balancer succeeded")
+	}
+	picker, err := balancer.Get("counting").Build(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := countingBuilds.Load()
+	c, err := grpcx.Dial(addr, grpcx.WithBalancer(picker), grpcx.WithBalancerName("counting"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := countingBuilds.Load() - before; got != 0 {
+		t.Fatalf("balancer set with WithBalancer was replaced")
+	}
+}
+
+// doneRecorder records the outcome of the calls it picked.
+type doneRecorder struct {
+	balancer.Picker
+	mu    sync.Mutex
+	infos []balancer.DoneInfo
+}
+
+func (r *doneRecorder) Pick(ctx context.Context, info balancer.PickInfo) (balancer.PickResult, error) {
+	res, err := r.Picker.Pick(ctx, info)
+	if err != nil {
+		return res, err
+	}
+	done := res.Done
+	res.Done = func(di balancer.DoneInfo) {
+		r.mu.Lock()
+		r.infos = append(r.infos, di)
+		r.mu.Unlock()
+		done(di)
+	}
+	return res, nil
+}
+
+// dones returns the outcome of the calls which finished.
+func (r *doneRecorder) dones() []balancer.DoneInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]balancer.DoneInfo(nil), r.infos...)
+}
+
+func TestPickDone(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+	})
+	picker, err := balancer.Build(context.Background(), balancer.P2CName, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &doneRecorder{Picker: picker}
+	c, err := grpcx.Dial(addr, grpcx.WithBalancer(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := api.NewEchoServiceClient(c)
+
+	if _, err := client.Echo(context.Background(), &api.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.ServerStreamingEcho(context.Background(), &api.EchoRequest{Message: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(r.dones()); got != 1 {
+		t.Fatalf("got %d calls done before the stream finished, want 1", got)
+	}
+	for {
+		if _, err := stream.Recv(); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := client.BidirectionalStreamingEcho(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	for deadline := time.Now().Add(5 * time.Second); len(r.dones()) < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d calls done, want 3", len(r.dones()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dones := r.dones()
+	if dones[0].Err != nil || dones[1].Err != nil || !errors.Is(dones[2].Err, context.Canceled) {
+		t.Fatalf("got calls done with %v, want nil, nil, %v", dones, context.Canceled)
+	}
+	for _, sc := range picker.(*balancer.P2C).SubConns() {
+		if got := sc.InFlight(); got != 0 {
+			t.Fatalf("%v has %d calls in flight after all finished", sc, got)
+		}
+	}
+}
+
+// unaryStreamDesc serves a unary call over a stream, as a client makes it
+// with a StreamDesc which is neither client nor server streaming.
+var unaryStreamDesc = grpc.ServiceDesc{
+	ServiceName: "test.UnaryStream",
+	HandlerType: (*any)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Echo",
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			var req api.EchoRequest
+			if err := stream.RecvMsg(&req); err != nil {
+				return err
+			}
+			return stream.SendMsg(&api.EchoResponse{Message: req.Message})
+		},
+	}},
+}
+
+func TestPickDoneNonServerStreaming(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+		s.RegisterService(&unaryStreamDesc, struct{}{})
+	})
+	picker, err := balancer.Build(context.Background(), balancer.P2CName, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &doneRecorder{Picker: picker}
+	c, err := grpcx.Dial(addr, grpcx.WithBalancer(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	// A client streaming call is done once it received the response.
+	stream, err := api.NewEchoServiceClient(c).ClientStreamingEcho(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&api.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatal(err)
+	}
+	// So is a unary call made over a stream.
+	unary, err := c.NewStream(ctx, &grpc.StreamDesc{}, "/test.UnaryStream/Echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unary.SendMsg(&api.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := unary.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := unary.RecvMsg(&api.EchoResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dones := r.dones()
+	if len(dones) != 2 || dones[0].Err != nil || dones[1].Err != nil {
+		t.Fatalf("got calls done with %v, want nil, nil", dones)
+	}
+	for _, sc := range picker.(*balancer.P2C).SubConns() {
+		if got := sc.InFlight(); got != 0 {
+			t.Fatalf("%v has %d calls in flight after all finished", sc, got)
+		}
+	}
+}
+
+func TestServerLoadReport(t *testing.T) {
+	rec := orca.NewRecorder()
+	rec.SetCPUUtilization(0.5)
+	rec.SetQPS(100)
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+	}, roundtrip.UnaryServerInterceptor(orca.UnaryServerInterceptor(rec)))
+	picker, err := balancer.Build(context.Background(), balancer.WeightedRoundRobinName, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &doneRecorder{Picker: picker}
+	c, err := grpcx.Dial(addr, grpcx.WithBalancer(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := api.NewEchoServiceClient(c).Echo(context.Background(), &api.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	dones := r.dones()
+	if len(dones) != 1 || dones[0].ServerLoad == nil {
+		t.Fatalf("got calls done with %+v, want a load report", dones)
+	}
+	if got, want := *dones[0].ServerLoad, rec.LoadReport(); got != want {
+		t.Fatalf("got load report %+v, want %+v", got, want)
+	}
+}
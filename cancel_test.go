@@ -0,0 +1,116 @@
+package grpcx_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// cancelHandler works on every call until its context is canceled and then
+// reports that it stopped.
+type cancelHandler struct {
+	api.UnimplementedEchoServiceServer
+	entered chan struct{}
+	stopped chan struct{}
+}
+
+func newCancelHandler() *cancelHandler {
+	return &cancelHandler{
+		entered: make(chan struct{}, 1),
+		stopped: make(chan struct{}, 1),
+	}
+}
+
+func (h *cancelHandler) work(ctx context.Context) error {
+	h.entered <- struct{}{}
+	<-ctx.Done()
+	h.stopped <- struct{}{}
+	return ctx.Err()
+}
+
+func (h *cancelHandler) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	return nil, h.work(ctx)
+}
+
+func (h *cancelHandler) BidirectionalStreamingEcho(stream grpc.BidiStreamingServer[api.EchoRequest, api.EchoResponse]) error {
+	return h.work(stream.Context())
+}
+
+func (h *cancelHandler) waitStopped(t *testing.T) {
+	t.Helper()
+	select {
+	case <-h.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("handler still running after the call was canceled")
+	}
+}
+
+func TestCancelUnary(t *testing.T) {
+	h := newCancelHandler()
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, h)
+	})
+	rt, err := roundtrip.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+
+	// Without a deadline the handler only stops once it is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := client.Echo(ctx, &api.EchoRequest{Message: "cancel"}, roundtrip.CallTimeout(0))
+		errc <- err
+	}()
+	<-h.entered
+	cancel()
+	if err := <-errc; status.Code(err) != codes.Canceled {
+		t.Fatalf("got %v, want %v", err, codes.Canceled)
+	}
+	h.waitStopped(t)
+
+	// The deadline of the client cancels the handler as well.
+	_, err = client.Echo(context.Background(), &api.EchoRequest{Message: "deadline"}, roundtrip.CallTimeout(100*time.Millisecond))
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, codes.DeadlineExceeded)
+	}
+	<-h.entered
+	h.waitStopped(t)
+}
+
+func TestCancelStream(t *testing.T) {
+	h := newCancelHandler()
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, h)
+	})
+	rt, err := roundtrip.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.BidirectionalStreamingEcho(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-h.entered
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Fatalf("got %v, want %v", err, codes.Canceled)
+	}
+	h.waitStopped(t)
+}
@@ -2,7 +2,9 @@ package grpcx
 
 import (
 	"context"
+	"errors"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/vimcoders/grpcx/roundtrip"
@@ -13,7 +15,12 @@ import (
 
 	"github.com/vimcoders/grpcx/encoding"
 
+	"github.com/vimcoders/grpcx/metadata"
+
+	"github.com/vimcoders/grpcx/orca"
+
 	"google.golang.org/grpc"
+	grpcmetadata "google.golang.org/grpc/metadata"
 )
 
 type ClientConnInterface interface {
@@ -32,13 +39,41 @@ func WithCodec(c encoding.Codec) Option {
 	}
 }
 
-// WithBalancer sets the balancer for the ttrpc client.
+// WithBalancer sets the balancer for the ttrpc client. It takes precedence
+// over WithBalancerName and WithDefaultServiceConfig.
 func WithBalancer(b balancer.Picker) Option {
 	return func(c *client) {
 		c.Picker = b
 	}
 }
 
+// WithBalancerName sets the name of the balancer registered with
+// balancer.Register the ttrpc client uses. It takes precedence over
+// WithDefaultServiceConfig, without either the round robin balancer is used.
+func WithBalancerName(name string) Option {
+	return func(c *client) {
+		c.balancerName = name
+	}
+}
+
+// WithDefaultServiceConfig sets the service config in JSON selecting the
+// balancer of the ttrpc client, e.g. {"loadBalancingConfig": [{"round_robin": {}}]}.
+func WithDefaultServiceConfig(js string) Option {
+	return func(c *client) {
+		c.serviceConfig = js
+	}
+}
+
+// WithSubset makes the ttrpc client connect to a subset of size of the
+// backends of its target, chosen for the client ID, see resolver.Subset. It
+// has no effect with WithBalancer.
+func WithSubset(clientID string, size int) Option {
+	return func(c *client) {
+		c.subsetClientID = clientID
+		c.subsetSize = size
+	}
+}
+
 // WithUnaryClientInterceptor sets the unary client interceptor for the ttrpc client.
 func WithUnaryClientInterceptor(i UnaryClientInterceptor) Option {
 	return func(c *client) {
@@ -46,7 +81,7 @@ func WithUnaryClientInterceptor(i UnaryClientInterceptor) Option {
 	}
 }
 
-// WithTimeout sets the timeout for the ttrpc client.
+// WithTimeout sets the timeout for calls of the ttrpc client whose context has no deadline. Zero means such calls have no deadline.
 func WithTimeout(d time.Duration) Option {
 	return func(c *client) {
 		c.opts = append(c.opts, roundtrip.WithTimeout(d))
@@ -60,15 +95,64 @@ func WithMaxStreams(n int) Option {
 	}
 }
 
+// WithMaxConns sets the maximum number of connections of the ttrpc client to each backend, see roundtrip.WithMaxConns.
+func WithMaxConns(n int) Option {
+	return func(c *client) {
+		c.opts = append(c.opts, roundtrip.WithMaxConns(n))
+	}
+}
+
+// WithIdleTimeout sets the time after which an additional connection of the ttrpc client with no stream is closed.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *client) {
+		c.opts = append(c.opts, roundtrip.WithIdleTimeout(d))
+	}
+}
+
+// WithWaitForStream makes calls of the ttrpc client wait for a stream until their deadline instead of failing with ResourceExhausted.
+func WithWaitForStream(wait bool) Option {
+	return func(c *client) {
+		c.opts = append(c.opts, roundtrip.WithWaitForStream(wait))
+	}
+}
+
+// WithMaxRecvMsgSize sets the maximum size of a message the ttrpc client can receive.
+func WithMaxRecvMsgSize(n int) Option {
+	return func(c *client) {
+		c.opts = append(c.opts, roundtrip.WithMaxRecvMsgSize(n))
+	}
+}
+
+// WithMaxSendMsgSize sets the maximum size of a message the ttrpc client can send.
+func WithMaxSendMsgSize(n int) Option {
+	return func(c *client) {
+		c.opts = append(c.opts, roundtrip.WithMaxSendMsgSize(n))
+	}
+}
+
+// WithConnectParams sets the backoff between redials of a broken connection of the ttrpc client.
+func WithConnectParams(p grpc.ConnectParams) Option {
+	return func(c *client) {
+		c.opts = append(c.opts, roundtrip.WithConnectParams(p))
+	}
+}
+
 // Client is a ttrpc client that handles outgoing requests and dispatches them to the appropriate transport.
 type client struct {
 	balancer.Picker
 	encoding.Codec
 	interceptor UnaryClientInterceptor
 	grpc.UnaryClientInterceptor
-	opts []roundtrip.Option
+	opts           []roundtrip.Option
+	balancerName   string
+	serviceConfig  string
+	subsetClientID string
+	subsetSize     int
 }
 
+// DialContext creates a ttrpc client for the endpoint, a target such as
+// host:port, dns:///svc:8080 or static:///a:1,b:2 resolved by the resolver
+// registered for its scheme.
 func DialContext(ctx context.Context, endpoint string, opts ...Option) (ClientConnInterface, error) {
 	c := client{
 		Codec: encoding.GetCodec(encoding.Name),
@@ -79,7 +163,24 @@ func DialContext(ctx context.Context, endpoint string, opts ...Option) (ClientCo
 	for _, o := range opts {
 		o(&c)
 	}
-	picker, err := balancer.DialContext(ctx, endpoint, c.opts...)
+	if c.Picker != nil {
+		return &c, nil
+	}
+	name := c.balancerName
+	if name == "" && c.serviceConfig != "" {
+		policy, err := balancer.PolicyFromServiceConfig(c.serviceConfig)
+		if err != nil {
+			return nil, err
+		}
+		name = policy
+	}
+	if name == "" {
+		name = balancer.RoundRobinName
+	}
+	if c.subsetSize > 0 {
+		ctx = balancer.WithSubset(ctx, c.subsetClientID, c.subsetSize)
+	}
+	picker, err := balancer.Build(ctx, name, endpoint, c.opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -91,43 +192,108 @@ func Dial(endpoint string, opts ...Option) (ClientConnInterface, error) {
 	return DialContext(context.Background(), endpoint, opts...)
 }
 
-func (c *client) Invoke(ctx context.Context, method string, req any, reply any, opts ...grpc.CallOption) error {
-	info := balancer.PickInfo{
+// pickInfo returns the information the balancer picks the round tripper of a
+// call with.
+func pickInfo(ctx context.Context, method string) balancer.PickInfo {
+	md, _ := metadata.GetMetadata(ctx)
+	return balancer.PickInfo{
 		FullMethodName: method,
+		Metadata:       md,
+		HashKey:        balancer.HashKey(ctx),
 	}
-	rt, err := c.Pick(ctx, info)
+}
+
+func (c *client) Invoke(ctx context.Context, method string, req any, reply any, opts ...grpc.CallOption) error {
+	info := pickInfo(ctx, method)
+	res, err := c.Pick(ctx, info)
 	if err != nil {
 		return err
 	}
-	if err := c.interceptor(ctx, method, req, reply, rt, opts...); err != nil {
-		return err
+	if res.Done == nil {
+		return c.interceptor(ctx, method, req, reply, res.RoundTripper, opts...)
 	}
-	return nil
+	var trailer grpcmetadata.MD
+	err = c.interceptor(ctx, method, req, reply, res.RoundTripper, append(opts[:len(opts):len(opts)], grpc.Trailer(&trailer))...)
+	res.Done(balancer.DoneInfo{Err: err, ServerLoad: orca.FromTrailer(trailer)})
+	return err
 }
 
 func (c *client) RoundTrip(ctx context.Context, req *api.Request) (*api.Response, error) {
-	info := balancer.PickInfo{
-		FullMethodName: req.Method,
-	}
-	rt, err := c.Pick(ctx, info)
+	info := pickInfo(ctx, req.Method)
+	res, err := c.Pick(ctx, info)
 	if err != nil {
 		return nil, err
 	}
-	return rt.RoundTrip(ctx, req)
+	resp, err := res.RoundTripper.RoundTrip(ctx, req)
+	if res.Done != nil {
+		var serverLoad *orca.LoadReport
+		if resp != nil {
+			serverLoad = orca.FromTrailer(roundtrip.PairsMetadata(resp.Trailers))
+		}
+		res.Done(balancer.DoneInfo{Err: err, ServerLoad: serverLoad})
+	}
+	return resp, err
 }
 
 // NewStream creates a new stream with the given stream descriptor to the
 // specified service and method. If not a streaming client, the request object
 // may be provided.
 func (c *client) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-	info := balancer.PickInfo{
-		FullMethodName: method,
+	info := pickInfo(ctx, method)
+	res, err := c.Pick(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := res.RoundTripper.NewStream(ctx, desc, method, opts...)
+	if res.Done == nil {
+		return stream, err
 	}
-	rt, err := c.Pick(ctx, info)
 	if err != nil {
+		res.Done(balancer.DoneInfo{Err: err})
 		return nil, err
 	}
-	return rt.NewStream(ctx, desc, method, opts...)
+	return newDoneStream(ctx, desc, stream, res.Done), nil
+}
+
+// doneStream is a client stream which tells its balancer when it finished,
+// once it received its last message or failed, or once its context is done.
+type doneStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	once sync.Once
+	done func(balancer.DoneInfo)
+	stop func() bool
+}
+
+func newDoneStream(ctx context.Context, desc *grpc.StreamDesc, stream grpc.ClientStream, done func(balancer.DoneInfo)) *doneStream {
+	s := &doneStream{ClientStream: stream, desc: desc, done: done}
+	s.stop = context.AfterFunc(ctx, func() {
+		s.finish(ctx.Err(), nil)
+	})
+	return s
+}
+
+// finish calls done once.
+func (s *doneStream) finish(err error, serverLoad *orca.LoadReport) {
+	s.once.Do(func() {
+		s.done(balancer.DoneInfo{Err: err, ServerLoad: serverLoad})
+	})
+}
+
+func (s *doneStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	// A stream which is not server streaming finished with its only
+	// message.
+	if err != nil || !s.desc.ServerStreams {
+		s.stop()
+		serverLoad := orca.FromTrailer(s.Trailer())
+		if errors.Is(err, io.EOF) {
+			s.finish(nil, serverLoad)
+		} else {
+			s.finish(err, serverLoad)
+		}
+	}
+	return err
 }
 
 func (c *client) Close() error {
@@ -0,0 +1,139 @@
+package grpcx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// deadlineHandler replies with the time remaining until the deadline of the
+// call, or "none" without deadline. Calls asking for "wait" block until the
+// deadline passes.
+type deadlineHandler struct {
+	api.UnimplementedEchoServiceServer
+}
+
+func (h *deadlineHandler) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	if req.Message == "wait" {
+		<-ctx.Done()
+		return nil, errors.New("gave up waiting")
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return &api.EchoResponse{Message: "none"}, nil
+	}
+	return &api.EchoResponse{Message: time.Until(deadline).String()}, nil
+}
+
+func remaining(t *testing.T, resp *api.EchoResponse) time.Duration {
+	t.Helper()
+	if resp.Message == "none" {
+		return 0
+	}
+	d, err := time.ParseDuration(resp.Message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &deadlineHandler{})
+	})
+	c, err := grpcx.Dial(addr, grpcx.WithTimeout(3*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := api.NewEchoServiceClient(c)
+	req := &api.EchoRequest{Message: "deadline"}
+
+	// Without a deadline on the context the timeout of the client applies.
+	resp, err := client.Echo(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := remaining(t, resp); d <= 2*time.Second || d > 3*time.Second {
+		t.Fatalf("got %v, want the client timeout", d)
+	}
+
+	// A longer deadline on the context is honoured.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err = client.Echo(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := remaining(t, resp); d <= 9*time.Second || d > 10*time.Second {
+		t.Fatalf("got %v, want the context deadline", d)
+	}
+
+	// A CallTimeout option overrides the timeout of the client.
+	resp, err = client.Echo(context.Background(), req, roundtrip.CallTimeout(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := remaining(t, resp); d <= 59*time.Second || d > time.Minute {
+		t.Fatalf("got %v, want the call timeout", d)
+	}
+
+	// A zero CallTimeout without deadline on the context means no deadline.
+	resp, err = client.Echo(context.Background(), req, roundtrip.CallTimeout(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message != "none" {
+		t.Fatalf("got %v, want no deadline", resp.Message)
+	}
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &deadlineHandler{})
+	})
+	rt, err := roundtrip.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = client.Echo(ctx, &api.EchoRequest{Message: "wait"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, codes.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("call took %v, want about 100ms", elapsed)
+	}
+	// The server maps the expiry to DeadlineExceeded as well.
+	payload, err := proto.Marshal(&api.EchoRequest{Message: "wait"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := rt.RoundTrip(context.Background(), &api.Request{
+		Method:  api.EchoService_Echo_FullMethodName,
+		Payload: payload,
+		Timeout: 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codes.Code(response.Code) != codes.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", codes.Code(response.Code), codes.DeadlineExceeded)
+	}
+}
@@ -3,7 +3,9 @@ package grpcx_test
 import (
 	"context"
 	"log"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/vimcoders/grpcx/status"
 
@@ -23,8 +25,11 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 var (
@@ -117,6 +122,109 @@ func RetriesUnaryClientInterceptor(retries int32) grpcx.UnaryClientInterceptor {
 	}
 }
 
+// quotaHandler fails the first calls with ResourceExhausted, telling how long
+// to back off when delay is set.
+type quotaHandler struct {
+	api.UnimplementedEchoServiceServer
+	delay time.Duration
+	fail  int32
+	calls atomic.Int32
+}
+
+func (h *quotaHandler) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	if h.calls.Add(1) > h.fail {
+		return &api.EchoResponse{Message: req.Message}, nil
+	}
+	details := []proto.Message{&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{
+		{Subject: "user:1", Description: "too many requests"},
+	}}}
+	if h.delay > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(h.delay)})
+	}
+	return nil, status.ErrorWithDetails(codes.ResourceExhausted, "slow down", details...)
+}
+
+// RetryDelayUnaryClientInterceptor retries calls failing with
+// ResourceExhausted after the delay the server said to back off, calls
+// failing without one are not retried.
+func RetryDelayUnaryClientInterceptor(retries int32) grpcx.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req any, reply any, rt roundtrip.RoundTripper, opts ...grpc.CallOption) error {
+		for i := retries; i >= 0; i-- {
+			err := rt.Invoke(ctx, method, req, reply, opts...)
+			if status.Code(err) != codes.ResourceExhausted {
+				return err
+			}
+			d, ok := status.RetryDelay(err)
+			if !ok {
+				return err
+			}
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return err
+			}
+		}
+		return status.OutOfRange.Err()
+	}
+}
+
+func TestRetriesRetryDelay(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		delay time.Duration
+		calls int32
+		code  codes.Code
+	}{
+		{name: "retry info", delay: 20 * time.Millisecond, calls: 2, code: codes.OK},
+		{name: "no retry info", calls: 1, code: codes.ResourceExhausted},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &quotaHandler{delay: tt.delay, fail: 1}
+			addr := newTestServer(t, func(s *grpcx.Server) {
+				api.RegisterEchoServiceServer(s, h)
+			})
+			c, err := grpcx.Dial(addr, grpcx.WithUnaryClientInterceptor(RetryDelayUnaryClientInterceptor(3)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			start := time.Now()
+			_, err = api.NewEchoServiceClient(c).Echo(context.Background(), &api.EchoRequest{Message: "hello"})
+			if status.Code(err) != tt.code {
+				t.Fatalf("got %v, want %v", err, tt.code)
+			}
+			if got := h.calls.Load(); got != tt.calls {
+				t.Fatalf("got %d calls, want %d", got, tt.calls)
+			}
+			if elapsed := time.Since(start); elapsed < tt.delay {
+				t.Fatalf("retried after %v, want at least %v", elapsed, tt.delay)
+			}
+		})
+	}
+}
+
+// TestDetailsRoundTrip checks the details of a status survive the wire.
+func TestDetailsRoundTrip(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &quotaHandler{delay: 2 * time.Second, fail: 1})
+	})
+	c, err := grpcx.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, err = api.NewEchoServiceClient(c).Echo(context.Background(), &api.EchoRequest{Message: "hello"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want %v", err, codes.ResourceExhausted)
+	}
+	if d, ok := status.RetryDelay(err); !ok || d != 2*time.Second {
+		t.Fatalf("got %v %v, want %v", d, ok, 2*time.Second)
+	}
+	if v := status.QuotaViolations(err); len(v) != 1 || v[0].Subject != "user:1" {
+		t.Fatalf("got %v", v)
+	}
+}
+
 func OtelUnaryClientInterceptor() grpcx.UnaryClientInterceptor {
 	var tracer = otel.Tracer("grpc-client-retries")
 	var propagator = otel.GetTextMapPropagator()
@@ -9,6 +9,7 @@ package api
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	anypb "google.golang.org/protobuf/types/known/anypb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -102,6 +103,9 @@ type Response struct {
 	Code          int32                  `protobuf:"varint,1,opt,name=Code,proto3" json:"Code,omitempty"`
 	Message       string                 `protobuf:"bytes,2,opt,name=Message,proto3" json:"Message,omitempty"`
 	Payload       []byte                 `protobuf:"bytes,3,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	Details       []*anypb.Any           `protobuf:"bytes,4,rep,name=Details,proto3" json:"Details,omitempty"`
+	Headers       []string               `protobuf:"bytes,5,rep,name=Headers,proto3" json:"Headers,omitempty"`
+	Trailers      []string               `protobuf:"bytes,6,rep,name=Trailers,proto3" json:"Trailers,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -157,21 +161,45 @@ func (x *Response) GetPayload() []byte {
 	return nil
 }
 
+func (x *Response) GetDetails() []*anypb.Any {
+	if x != nil {
+		return x.Details
+	}
+	return nil
+}
+
+func (x *Response) GetHeaders() []string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *Response) GetTrailers() []string {
+	if x != nil {
+		return x.Trailers
+	}
+	return nil
+}
+
 var File_proto_api_proto protoreflect.FileDescriptor
 
 const file_proto_api_proto_rawDesc = "" +
 	"\n" +
-	"\x0fproto/api.proto\x12\x03api\"\x8d\x01\n" +
+	"\x0fproto/api.proto\x12\x03api\x1a\x19google/protobuf/any.proto\"\x8d\x01\n" +
 	"\aRequest\x12\x18\n" +
 	"\aService\x18\x01 \x01(\tR\aService\x12\x16\n" +
 	"\x06Method\x18\x02 \x01(\tR\x06Method\x12\x18\n" +
 	"\aPayload\x18\x03 \x01(\fR\aPayload\x12\x18\n" +
 	"\aTimeout\x18\x04 \x01(\x03R\aTimeout\x12\x1c\n" +
-	"\tMetadatas\x18\x05 \x03(\tR\tMetadatas\"R\n" +
+	"\tMetadatas\x18\x05 \x03(\tR\tMetadatas\"\xb8\x01\n" +
 	"\bResponse\x12\x12\n" +
 	"\x04Code\x18\x01 \x01(\x05R\x04Code\x12\x18\n" +
 	"\aMessage\x18\x02 \x01(\tR\aMessage\x12\x18\n" +
-	"\aPayload\x18\x03 \x01(\fR\aPayloadB\bZ\x06./;apib\x06proto3"
+	"\aPayload\x18\x03 \x01(\fR\aPayload\x12.\n" +
+	"\aDetails\x18\x04 \x03(\v2\x14.google.protobuf.AnyR\aDetails\x12\x18\n" +
+	"\aHeaders\x18\x05 \x03(\tR\aHeaders\x12\x1a\n" +
+	"\bTrailers\x18\x06 \x03(\tR\bTrailersB\bZ\x06./;apib\x06proto3"
 
 var (
 	file_proto_api_proto_rawDescOnce sync.Once
@@ -187,15 +215,17 @@ func file_proto_api_proto_rawDescGZIP() []byte {
 
 var file_proto_api_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_proto_api_proto_goTypes = []any{
-	(*Request)(nil),  // 0: api.Request
-	(*Response)(nil), // 1: api.Response
+	(*Request)(nil),   // 0: api.Request
+	(*Response)(nil),  // 1: api.Response
+	(*anypb.Any)(nil), // 2: google.protobuf.Any
 }
 var file_proto_api_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	2, // 0: api.Response.Details:type_name -> google.protobuf.Any
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_proto_api_proto_init() }
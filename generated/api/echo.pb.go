@@ -117,9 +117,12 @@ const file_proto_echo_proto_rawDesc = "" +
 	"\vEchoRequest\x12\x18\n" +
 	"\amessage\x18\x01 \x01(\tR\amessage\"(\n" +
 	"\fEchoResponse\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\tR\amessage2:\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage2\xfd\x01\n" +
 	"\vEchoService\x12+\n" +
-	"\x04Echo\x12\x10.api.EchoRequest\x1a\x11.api.EchoResponseB\bZ\x06./;apib\x06proto3"
+	"\x04Echo\x12\x10.api.EchoRequest\x1a\x11.api.EchoResponse\x12<\n" +
+	"\x13ServerStreamingEcho\x12\x10.api.EchoRequest\x1a\x11.api.EchoResponse0\x01\x12<\n" +
+	"\x13ClientStreamingEcho\x12\x10.api.EchoRequest\x1a\x11.api.EchoResponse(\x01\x12E\n" +
+	"\x1aBidirectionalStreamingEcho\x12\x10.api.EchoRequest\x1a\x11.api.EchoResponse(\x010\x01B\bZ\x06./;apib\x06proto3"
 
 var (
 	file_proto_echo_proto_rawDescOnce sync.Once
@@ -140,9 +143,15 @@ var file_proto_echo_proto_goTypes = []any{
 }
 var file_proto_echo_proto_depIdxs = []int32{
 	0, // 0: api.EchoService.Echo:input_type -> api.EchoRequest
-	1, // 1: api.EchoService.Echo:output_type -> api.EchoResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
+	0, // 1: api.EchoService.ServerStreamingEcho:input_type -> api.EchoRequest
+	0, // 2: api.EchoService.ClientStreamingEcho:input_type -> api.EchoRequest
+	0, // 3: api.EchoService.BidirectionalStreamingEcho:input_type -> api.EchoRequest
+	1, // 4: api.EchoService.Echo:output_type -> api.EchoResponse
+	1, // 5: api.EchoService.ServerStreamingEcho:output_type -> api.EchoResponse
+	1, // 6: api.EchoService.ClientStreamingEcho:output_type -> api.EchoResponse
+	1, // 7: api.EchoService.BidirectionalStreamingEcho:output_type -> api.EchoResponse
+	4, // [4:8] is the sub-list for method output_type
+	0, // [0:4] is the sub-list for method input_type
 	0, // [0:0] is the sub-list for extension type_name
 	0, // [0:0] is the sub-list for extension extendee
 	0, // [0:0] is the sub-list for field type_name
@@ -0,0 +1,160 @@
+package grpcx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var server *grpcx.Server
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+		server = s
+	})
+	c, err := grpcx.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := healthpb.NewHealthClient(c)
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+	// The server and its registered services are serving.
+	for _, service := range []string{"", api.EchoService_ServiceDesc.ServiceName} {
+		if got := check(service); got != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("got %v for %q, want %v", got, service, healthpb.HealthCheckResponse_SERVING)
+		}
+	}
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("got %v, want %v", err, codes.NotFound)
+	}
+	if err := server.SetServingStatus(api.EchoService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(api.EchoService_ServiceDesc.ServiceName); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("got %v, want %v", got, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+func TestHealthWatchDrain(t *testing.T) {
+	var server *grpcx.Server
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+		server = s
+	})
+	c, err := grpcx.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := healthpb.NewHealthClient(c).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != want {
+			t.Fatalf("got %v, want %v", resp.Status, want)
+		}
+	}
+	recv(healthpb.HealthCheckResponse_SERVING)
+	if err := server.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	recv(healthpb.HealthCheckResponse_NOT_SERVING)
+	// Calls are still served while draining.
+	if _, err := api.NewEchoServiceClient(c).Echo(ctx, &api.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	recv(healthpb.HealthCheckResponse_SERVING)
+}
+
+// TestHealthReplaced checks the serving status cannot be set once another
+// health service is registered, which then reports it.
+func TestHealthReplaced(t *testing.T) {
+	var server *grpcx.Server
+	hs := health.NewServer()
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+		healthpb.RegisterHealthServer(s, hs)
+		server = s
+	})
+	if err := server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING); !errors.Is(err, grpcx.ErrHealthReplaced) {
+		t.Fatalf("got %v, want %v", err, grpcx.ErrHealthReplaced)
+	}
+	if err := server.Drain(); !errors.Is(err, grpcx.ErrHealthReplaced) {
+		t.Fatalf("got %v, want %v", err, grpcx.ErrHealthReplaced)
+	}
+	if err := server.Resume(); !errors.Is(err, grpcx.ErrHealthReplaced) {
+		t.Fatalf("got %v, want %v", err, grpcx.ErrHealthReplaced)
+	}
+	c, err := grpcx.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	resp, err := healthpb.NewHealthClient(c).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("got %v, want %v", resp.Status, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// TestHealthServeTwice checks a server serves the health service again once
+// it is served on another listener.
+func TestHealthServeTwice(t *testing.T) {
+	s := grpcx.NewServer()
+	api.RegisterEchoServiceServer(s, &TTHandler{})
+	for range 2 {
+		lis := listen(t, "tcp", "127.0.0.1:0")
+		served := make(chan error, 1)
+		go func() {
+			served <- s.Serve(context.Background(), lis)
+		}()
+		c, err := grpcx.Dial(lis.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := healthpb.NewHealthClient(c).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("got %v, want %v", resp.Status, healthpb.HealthCheckResponse_SERVING)
+		}
+		c.Close()
+		lis.Close()
+		<-served
+	}
+}
@@ -21,6 +21,9 @@ import (
 	"fmt"
 	"maps"
 	"strings"
+
+	"google.golang.org/grpc"
+	grpcmetadata "google.golang.org/grpc/metadata"
 )
 
 // MD is the user type for ttrpc metadata
@@ -111,3 +114,29 @@ func AppendToContext(ctx context.Context, kv ...string) context.Context {
 	}
 	return WithMetadata(ctx, md)
 }
+
+// SetHeader sets the header metadata to be sent from the server to the client.
+// The context provided must be the context passed to the server's handler.
+//
+// When called multiple times, all the provided metadata will be merged. All
+// the metadata will be sent out when one of the following happens:
+//   - SendHeader is called;
+//   - the first response of a streaming handler is sent;
+//   - the call finishes.
+func SetHeader(ctx context.Context, md MD) error {
+	return grpc.SetHeader(ctx, grpcmetadata.New(md))
+}
+
+// SendHeader sends header metadata. It may be called at most once, and may not
+// be called after SetHeader once the header was sent. The provided md and
+// headers set by SetHeader will be sent.
+func SendHeader(ctx context.Context, md MD) error {
+	return grpc.SendHeader(ctx, grpcmetadata.New(md))
+}
+
+// SetTrailer sets the trailer metadata that will be sent when the call
+// finishes. When called more than once, all the provided metadata will be
+// merged.
+func SetTrailer(ctx context.Context, md MD) error {
+	return grpc.SetTrailer(ctx, grpcmetadata.New(md))
+}
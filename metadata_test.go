@@ -0,0 +1,111 @@
+package grpcx_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/vimcoders/grpcx/metadata"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx"
+
+	"google.golang.org/grpc"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// metadataHandler returns the message of every request in its header and
+// trailer metadata.
+type metadataHandler struct {
+	api.UnimplementedEchoServiceServer
+}
+
+func (h *metadataHandler) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	if err := metadata.SetHeader(ctx, metadata.Pairs("x-header", req.Message)); err != nil {
+		return nil, err
+	}
+	if err := metadata.SetTrailer(ctx, metadata.Pairs("x-trailer", req.Message)); err != nil {
+		return nil, err
+	}
+	return &api.EchoResponse{Message: req.Message}, nil
+}
+
+func (h *metadataHandler) BidirectionalStreamingEcho(stream grpc.BidiStreamingServer[api.EchoRequest, api.EchoResponse]) error {
+	if err := stream.SendHeader(grpcmetadata.Pairs("x-header", "stream")); err != nil {
+		return err
+	}
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stream.SetTrailer(grpcmetadata.Pairs("x-trailer", req.Message))
+		if err := stream.Send(&api.EchoResponse{Message: req.Message}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestUnaryHeaderAndTrailer(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &metadataHandler{})
+	})
+	c, err := grpcx.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var header, trailer grpcmetadata.MD
+	_, err = api.NewEchoServiceClient(c).Echo(context.Background(), &api.EchoRequest{Message: "hello"}, grpc.Header(&header), grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := header.Get("x-header"); len(v) != 1 || v[0] != "hello" {
+		t.Fatalf("got header %v", header)
+	}
+	if v := trailer.Get("x-trailer"); len(v) != 1 || v[0] != "hello" {
+		t.Fatalf("got trailer %v", trailer)
+	}
+}
+
+func TestStreamHeaderAndTrailer(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &metadataHandler{})
+	})
+	c, err := grpcx.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	stream, err := api.NewEchoServiceClient(c).BidirectionalStreamingEcho(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := header.Get("x-header"); len(v) != 1 || v[0] != "stream" {
+		t.Fatalf("got header %v", header)
+	}
+	if err := stream.Send(&api.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+	if v := stream.Trailer().Get("x-trailer"); len(v) != 1 || v[0] != "hello" {
+		t.Fatalf("got trailer %v", stream.Trailer())
+	}
+}
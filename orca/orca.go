@@ -0,0 +1,189 @@
+// Package orca reports the load of a server to the balancers of its clients,
+// in the text format of ORCA (Open Request Cost Aggregation) load reports.
+//
+// The server attaches a load report to the trailer of its responses, either
+// per call with SetLoadReport or for every call from a Recorder with
+// UnaryServerInterceptor. Balancers such as weighted round robin receive
+// the reports of their calls in balancer.DoneInfo.
+package orca
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vimcoders/grpcx/metadata"
+
+	"google.golang.org/grpc"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// TrailerKey is the trailer key of the load reports.
+const TrailerKey = "endpoint-load-metrics"
+
+// textPrefix is the prefix of a load report in the text format.
+const textPrefix = "TEXT "
+
+// LoadReport is the load of a server.
+type LoadReport struct {
+	// CPUUtilization is the CPU utilization of the server, usually between
+	// 0 and 1, above 1 if it uses more than its share.
+	CPUUtilization float64
+	// MemUtilization is the memory utilization of the server, between 0 and 1.
+	MemUtilization float64
+	// ApplicationUtilization is a utilization defined by the application,
+	// it takes precedence over CPUUtilization to weigh the server.
+	ApplicationUtilization float64
+	// QPS is the number of queries per second the server serves.
+	QPS float64
+	// EPS is the number of errors per second the server returns.
+	EPS float64
+}
+
+// String returns the load report in the text format, e.g.
+//
+//	TEXT cpu_utilization=0.5, rps_fractional=100
+//
+// Metrics which are zero are left out.
+func (r LoadReport) String() string {
+	var metrics []string
+	for _, m := range []struct {
+		name  string
+		value float64
+	}{
+		{"cpu_utilization", r.CPUUtilization},
+		{"mem_utilization", r.MemUtilization},
+		{"application_utilization", r.ApplicationUtilization},
+		{"rps_fractional", r.QPS},
+		{"eps", r.EPS},
+	} {
+		if m.value != 0 {
+			metrics = append(metrics, m.name+"="+strconv.FormatFloat(m.value, 'g', -1, 64))
+		}
+	}
+	return textPrefix + strings.Join(metrics, ", ")
+}
+
+// Parse parses a load report in the text format. Unknown metrics are
+// ignored.
+func Parse(s string) (*LoadReport, error) {
+	s, ok := strings.CutPrefix(s, textPrefix)
+	if !ok {
+		return nil, fmt.Errorf("orca: load report %q not in the text format", s)
+	}
+	var r LoadReport
+	for metric := range strings.SplitSeq(s, ",") {
+		metric = strings.TrimSpace(metric)
+		if metric == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(metric, "=")
+		if !ok {
+			return nil, fmt.Errorf("orca: invalid metric %q", metric)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("orca: invalid metric %q: %w", metric, err)
+		}
+		switch name {
+		case "cpu_utilization":
+			r.CPUUtilization = v
+		case "mem_utilization":
+			r.MemUtilization = v
+		case "application_utilization":
+			r.ApplicationUtilization = v
+		case "rps_fractional":
+			r.QPS = v
+		case "eps":
+			r.EPS = v
+		}
+	}
+	return &r, nil
+}
+
+// FromTrailer returns the load report in the trailer of a call, nil if there
+// is none or it is invalid.
+func FromTrailer(md grpcmetadata.MD) *LoadReport {
+	values := md.Get(TrailerKey)
+	if len(values) == 0 {
+		return nil
+	}
+	r, err := Parse(values[len(values)-1])
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
+// SetLoadReport attaches the load report to the trailer of the call of ctx,
+// the context passed to the server's handler.
+func SetLoadReport(ctx context.Context, r LoadReport) error {
+	return metadata.SetTrailer(ctx, metadata.Pairs(TrailerKey, r.String()))
+}
+
+// Recorder records the load of a server, it is safe for concurrent use.
+type Recorder struct {
+	mu     sync.RWMutex
+	report LoadReport
+}
+
+// NewRecorder creates a recorder with no load recorded.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// SetCPUUtilization records the CPU utilization of the server.
+func (r *Recorder) SetCPUUtilization(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.CPUUtilization = v
+}
+
+// SetMemUtilization records the memory utilization of the server.
+func (r *Recorder) SetMemUtilization(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.MemUtilization = v
+}
+
+// SetApplicationUtilization records the application defined utilization of
+// the server.
+func (r *Recorder) SetApplicationUtilization(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.ApplicationUtilization = v
+}
+
+// SetQPS records the queries per second the server serves.
+func (r *Recorder) SetQPS(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.QPS = v
+}
+
+// SetEPS records the errors per second the server returns.
+func (r *Recorder) SetEPS(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.EPS = v
+}
+
+// LoadReport returns the load recorded.
+func (r *Recorder) LoadReport() LoadReport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.report
+}
+
+// UnaryServerInterceptor returns an interceptor attaching the load recorded
+// by r to the trailer of every unary call, install it with
+// roundtrip.UnaryServerInterceptor.
+func UnaryServerInterceptor(r *Recorder) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		_ = SetLoadReport(ctx, r.LoadReport())
+		return resp, err
+	}
+}
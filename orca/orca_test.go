@@ -0,0 +1,51 @@
+package orca_test
+
+import (
+	"testing"
+
+	"github.com/vimcoders/grpcx/orca"
+
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+func TestLoadReportText(t *testing.T) {
+	r := orca.LoadReport{CPUUtilization: 0.5, ApplicationUtilization: 0.25, QPS: 100, EPS: 1.5}
+	s := r.String()
+	if want := "TEXT cpu_utilization=0.5, application_utilization=0.25, rps_fractional=100, eps=1.5"; s != want {
+		t.Fatalf("got %q, want %q", s, want)
+	}
+	got, err := orca.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != r {
+		t.Fatalf("got %+v, want %+v", *got, r)
+	}
+
+	// Unknown metrics are ignored.
+	got, err = orca.Parse("TEXT named_metrics.foo=1,mem_utilization=0.75")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (orca.LoadReport{MemUtilization: 0.75}); *got != want {
+		t.Fatalf("got %+v, want %+v", *got, want)
+	}
+	for _, s := range []string{"cpu_utilization=0.5", "TEXT cpu_utilization", "TEXT cpu_utilization=high"} {
+		if _, err := orca.Parse(s); err == nil {
+			t.Errorf("parsing %q succeeded", s)
+		}
+	}
+}
+
+func TestFromTrailer(t *testing.T) {
+	if got := orca.FromTrailer(nil); got != nil {
+		t.Fatalf("got %+v from no trailer", got)
+	}
+	if got := orca.FromTrailer(grpcmetadata.Pairs(orca.TrailerKey, "invalid")); got != nil {
+		t.Fatalf("got %+v from an invalid report", got)
+	}
+	got := orca.FromTrailer(grpcmetadata.Pairs(orca.TrailerKey, "TEXT rps_fractional=10"))
+	if got == nil || got.QPS != 10 {
+		t.Fatalf("got %+v, want QPS 10", got)
+	}
+}
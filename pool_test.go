@@ -0,0 +1,173 @@
+package grpcx_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx"
+
+	"google.golang.org/grpc/codes"
+)
+
+// countingListener counts the connections it accepted which are still open.
+type countingListener struct {
+	net.Listener
+	open atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.open.Add(1)
+	return &countedConn{Conn: c, open: &l.open}, nil
+}
+
+// countedConn is a connection counted by a countingListener until it is
+// closed.
+type countedConn struct {
+	net.Conn
+	once sync.Once
+	open *atomic.Int32
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.open.Add(-1)
+	})
+	return c.Conn.Close()
+}
+
+// serveSlow serves h on a counting listener.
+func serveSlow(t *testing.T, h *slowHandler) *countingListener {
+	t.Helper()
+	lis := &countingListener{Listener: listen(t, "tcp", "127.0.0.1:0")}
+	serveTestServer(t, lis, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, h)
+	})
+	return lis
+}
+
+// callSlow starts a call blocked by h, it returns once the call entered the
+// handler.
+func callSlow(client api.EchoServiceClient, h *slowHandler) <-chan error {
+	slow := make(chan error, 1)
+	go func() {
+		_, err := client.Echo(context.Background(), &api.EchoRequest{Message: "slow"})
+		slow <- err
+	}()
+	<-h.entered
+	return slow
+}
+
+func TestPoolGrows(t *testing.T) {
+	h := newSlowHandler()
+	lis := serveSlow(t, h)
+	rt, err := roundtrip.Dial(lis.Addr().String(), roundtrip.WithMaxStreams(1), roundtrip.WithMaxConns(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	first := callSlow(client, h)
+	// The first connection is saturated, the call goes to a second one.
+	second := callSlow(client, h)
+	if n := lis.open.Load(); n != 2 {
+		t.Fatalf("got %d connections, want 2", n)
+	}
+	// Both connections are saturated and the pool is full.
+	_, err = client.Echo(context.Background(), &api.EchoRequest{Message: "fast"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want %v", err, codes.ResourceExhausted)
+	}
+	close(h.release)
+	for _, slow := range []<-chan error{first, second} {
+		if err := <-slow; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPoolWaitForStream(t *testing.T) {
+	h := newSlowHandler()
+	lis := serveSlow(t, h)
+	rt, err := roundtrip.Dial(lis.Addr().String(), roundtrip.WithMaxStreams(1), roundtrip.WithWaitForStream(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	slow := callSlow(client, h)
+	// A call waits for a stream until its deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.Echo(ctx, &api.EchoRequest{Message: "fast"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, codes.DeadlineExceeded)
+	}
+	// A call waiting gets the stream once it is released.
+	fast := make(chan error, 1)
+	go func() {
+		_, err := client.Echo(context.Background(), &api.EchoRequest{Message: "fast"})
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		t.Fatalf("call finished while the stream was in use: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(h.release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-fast; err != nil {
+		t.Fatal(err)
+	}
+	if n := lis.open.Load(); n != 1 {
+		t.Fatalf("got %d connections, want 1", n)
+	}
+}
+
+func TestPoolClosesIdleConns(t *testing.T) {
+	h := newSlowHandler()
+	lis := serveSlow(t, h)
+	rt, err := roundtrip.Dial(lis.Addr().String(), roundtrip.WithMaxStreams(1), roundtrip.WithMaxConns(2), roundtrip.WithIdleTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	slow := callSlow(client, h)
+	if _, err := client.Echo(context.Background(), &api.EchoRequest{Message: "fast"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := lis.open.Load(); n != 2 {
+		t.Fatalf("got %d connections, want 2", n)
+	}
+	close(h.release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+	// The second connection is closed once idle, the first one is kept.
+	deadline := time.Now().Add(5 * time.Second)
+	for lis.open.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d connections, want 1", lis.open.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := client.Echo(context.Background(), &api.EchoRequest{Message: "fast"}); err != nil {
+		t.Fatal(err)
+	}
+}
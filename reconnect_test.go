@@ -0,0 +1,150 @@
+package grpcx_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+)
+
+var testConnectParams = grpc.ConnectParams{
+	Backoff: backoff.Config{
+		BaseDelay:  10 * time.Millisecond,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+		MaxDelay:   100 * time.Millisecond,
+	},
+	MinConnectTimeout: time.Second,
+}
+
+// trackingListener records the connections it accepts, so that a test can
+// break them.
+type trackingListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns = append(l.conns, c)
+	return c, nil
+}
+
+func (l *trackingListener) closeConns() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range l.conns {
+		_ = c.Close()
+	}
+	l.conns = nil
+}
+
+// serveEcho serves the echo service on a tracking listener on addr.
+func serveEcho(t *testing.T, addr string) *trackingListener {
+	t.Helper()
+	lis := &trackingListener{Listener: listen(t, "tcp", addr)}
+	serveTestServer(t, lis, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+	})
+	return lis
+}
+
+func waitForState(t *testing.T, rt roundtrip.RoundTripper, want connectivity.State) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for state := rt.GetState(); state != want; state = rt.GetState() {
+		if !rt.WaitForStateChange(ctx, state) {
+			t.Fatalf("state is %v, want %v", state, want)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	lis := serveEcho(t, "127.0.0.1:0")
+	rt, err := roundtrip.Dial(lis.Addr().String(), roundtrip.WithConnectParams(testConnectParams))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	ctx := context.Background()
+	if _, err := client.Echo(ctx, &api.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	lis.closeConns()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if !rt.WaitForStateChange(ctx, connectivity.Ready) {
+		t.Fatal("broken connection still Ready")
+	}
+	waitForState(t, rt, connectivity.Ready)
+	if _, err := client.Echo(ctx, &api.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	lis := serveEcho(t, "127.0.0.1:0")
+	addr := lis.Addr().String()
+	rt, err := roundtrip.Dial(addr, roundtrip.WithConnectParams(testConnectParams))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	// Take the server down, redialing fails from now on.
+	_ = lis.Close()
+	lis.closeConns()
+	waitForState(t, rt, connectivity.TransientFailure)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = client.Echo(ctx, &api.EchoRequest{Message: "hello"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("got %v, want %v", err, codes.Unavailable)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := client.Echo(ctx, &api.EchoRequest{Message: "hello"}, grpc.WaitForReady(true))
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		t.Fatalf("call did not wait for the server: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	serveEcho(t, addr)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	rt.Close()
+	if state := rt.GetState(); state != connectivity.Shutdown {
+		t.Fatalf("got %v, want %v", state, connectivity.Shutdown)
+	}
+	_, err = client.Echo(ctx, &api.EchoRequest{Message: "hello"})
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("got %v, want %v", err, codes.Canceled)
+	}
+}
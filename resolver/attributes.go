@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"maps"
+	"strings"
+)
+
+// zoneKey is the attribute key of the zone of an address.
+type zoneKey struct{}
+
+// zoneHintsKey is the attribute key of the zones an address should serve.
+type zoneHintsKey struct{}
+
+// WithZone returns addr with the zone of its backend set.
+func WithZone(addr Address, zone string) Address {
+	addr.Attributes = addr.Attributes.WithValue(zoneKey{}, zone)
+	return addr
+}
+
+// Zone returns the zone of the backend of addr, empty if it is unknown.
+func Zone(addr Address) string {
+	zone, _ := addr.Attributes.Value(zoneKey{}).(string)
+	return zone
+}
+
+// WithZoneHints returns addr with the zones it should serve set, as hinted
+// by topology aware routing.
+func WithZoneHints(addr Address, zones ...string) Address {
+	// Attribute values are compared, so the zones are kept as a string.
+	addr.Attributes = addr.Attributes.WithValue(zoneHintsKey{}, strings.Join(zones, ","))
+	return addr
+}
+
+// ZoneHints returns the zones addr should serve, nil if there are no hints.
+func ZoneHints(addr Address) []string {
+	zones, _ := addr.Attributes.Value(zoneHintsKey{}).(string)
+	if zones == "" {
+		return nil
+	}
+	return strings.Split(zones, ",")
+}
+
+// priorityKey is the attribute key of the priority of an address.
+type priorityKey struct{}
+
+// weightKey is the attribute key of the weight of an address.
+type weightKey struct{}
+
+// WithPriority returns addr with its priority set. Lower values are
+// preferred, as for the priority of SRV records.
+func WithPriority(addr Address, priority uint32) Address {
+	addr.Attributes = addr.Attributes.WithValue(priorityKey{}, priority)
+	return addr
+}
+
+// Priority returns the priority of addr and whether it is set.
+func Priority(addr Address) (uint32, bool) {
+	priority, ok := addr.Attributes.Value(priorityKey{}).(uint32)
+	return priority, ok
+}
+
+// WithWeight returns addr with its weight set, the share of calls of its
+// backend relative to the weights of the other addresses.
+func WithWeight(addr Address, weight uint32) Address {
+	addr.Attributes = addr.Attributes.WithValue(weightKey{}, weight)
+	return addr
+}
+
+// Weight returns the weight of addr and whether it is set.
+func Weight(addr Address) (uint32, bool) {
+	weight, ok := addr.Attributes.Value(weightKey{}).(uint32)
+	return weight, ok
+}
+
+// metadataKey is the attribute key of the metadata of an address.
+type metadataKey struct{}
+
+// metadata is the metadata of an address. Attribute values are compared, so
+// it implements Equal.
+type metadata map[string]string
+
+// Equal reports whether o is the same metadata.
+func (m metadata) Equal(o any) bool {
+	om, ok := o.(metadata)
+	return ok && maps.Equal(m, om)
+}
+
+// WithMetadata returns addr with metadata about its backend set, e.g. its
+// version.
+func WithMetadata(addr Address, md map[string]string) Address {
+	addr.Attributes = addr.Attributes.WithValue(metadataKey{}, metadata(maps.Clone(md)))
+	return addr
+}
+
+// Metadata returns the metadata of the backend of addr, nil if there is none.
+// It must not be modified.
+func Metadata(addr Address) map[string]string {
+	md, _ := addr.Attributes.Value(metadataKey{}).(metadata)
+	return md
+}
@@ -0,0 +1,153 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultFileResolveInterval is the default interval between two checks of
+// a watched file for changes.
+const defaultFileResolveInterval = time.Second
+
+// fileBuilder is the builder of the file resolver.
+type fileBuilder struct{}
+
+// Build builds a file resolver.
+func (b *fileBuilder) Build() (Resolver, error) {
+	return &fileResolver{poller: newPoller(defaultFileResolveInterval, 0)}, nil
+}
+
+// Scheme returns the scheme of the file resolver.
+func (b *fileBuilder) Scheme() string {
+	return "file"
+}
+
+// fileBackends is the content of the file of the file resolver, in YAML or
+// JSON:
+//
+//	addresses:
+//	  - addr: 10.0.0.1:8080
+//	    serverName: echo.internal
+//	    weight: 3
+//	    priority: 0
+//	    zone: zone-a
+//	    metadata:
+//	      version: v2
+type fileBackends struct {
+	Addresses []fileAddress `yaml:"addresses"`
+}
+
+// fileAddress is an address listed in the file of the file resolver.
+type fileAddress struct {
+	Addr       string            `yaml:"addr"`
+	ServerName string            `yaml:"serverName"`
+	Weight     *uint32           `yaml:"weight"`
+	Priority   *uint32           `yaml:"priority"`
+	Zone       string            `yaml:"zone"`
+	Metadata   map[string]string `yaml:"metadata"`
+}
+
+// fileResolver resolves file:///path, or file:path for a relative path, into
+// the addresses listed in the file. Watched files are checked for changes
+// every second.
+type fileResolver struct {
+	*poller
+}
+
+// filePath returns the path of the file of a target.
+func filePath(u url.URL) (string, error) {
+	path := u.Path
+	if u.Opaque != "" {
+		path = u.Opaque
+	}
+	if path == "" {
+		return "", errors.New("resolver: empty path")
+	}
+	return path, nil
+}
+
+// Resolve resolves a target into the addresses listed in its file.
+func (r *fileResolver) Resolve(u url.URL) ([]Address, error) {
+	path, err := filePath(u)
+	if err != nil {
+		return nil, err
+	}
+	return readBackends(path)
+}
+
+// Watch watches the file of a target, the addresses are sent again whenever
+// they change. A file which cannot be read or parsed keeps the last
+// addresses.
+func (r *fileResolver) Watch(ctx context.Context, u url.URL) (<-chan []Address, error) {
+	path, err := filePath(u)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		last    []Address
+		modTime time.Time
+		size    int64
+	)
+	return r.watch(ctx, func(ctx context.Context) ([]Address, error) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		// The file is only read again once it was modified.
+		if last != nil && fi.ModTime().Equal(modTime) && fi.Size() == size {
+			return last, nil
+		}
+		addrs, err := readBackends(path)
+		if err != nil {
+			return nil, err
+		}
+		last, modTime, size = addrs, fi.ModTime(), fi.Size()
+		return addrs, nil
+	})
+}
+
+// readBackends reads the addresses listed in a file.
+func readBackends(path string) ([]Address, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var backends fileBackends
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&backends); err != nil {
+		return nil, fmt.Errorf("resolver: parsing %s: %w", path, err)
+	}
+
+	var addrs []Address
+	for _, backend := range backends.Addresses {
+		if backend.Addr == "" {
+			return nil, fmt.Errorf("resolver: parsing %s: address without addr", path)
+		}
+		addr := Address{Addr: backend.Addr, ServerName: backend.ServerName}
+		if backend.Weight != nil {
+			addr = WithWeight(addr, *backend.Weight)
+		}
+		if backend.Priority != nil {
+			addr = WithPriority(addr, *backend.Priority)
+		}
+		if backend.Zone != "" {
+			addr = WithZone(addr, backend.Zone)
+		}
+		if len(backend.Metadata) > 0 {
+			addr = WithMetadata(addr, backend.Metadata)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("resolver: no addresses found")
+	}
+	return addrs, nil
+}
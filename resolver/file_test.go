@@ -0,0 +1,130 @@
+package resolver_test
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/resolver"
+)
+
+// writeBackends writes a backends file, its modification time is moved
+// forward so that the change is seen on file systems with a coarse clock.
+func writeBackends(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileResolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	writeBackends(t, path, `
+addresses:
+  - addr: 10.0.0.1:8080
+    serverName: echo.internal
+    weight: 3
+    priority: 1
+    zone: zone-a
+    metadata:
+      version: v2
+  - addr: 10.0.0.2:8080
+`, time.Now())
+	r, err := resolver.Get("file").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	address, err := r.Resolve(resolver.ParseTarget("file://" + path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := addrsOf(address), []string{"10.0.0.1:8080", "10.0.0.2:8080"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := address[0].ServerName; got != "echo.internal" {
+		t.Fatalf("got server name %q, want %q", got, "echo.internal")
+	}
+	if weight, ok := resolver.Weight(address[0]); !ok || weight != 3 {
+		t.Fatalf("got weight %d, %t, want 3", weight, ok)
+	}
+	if priority, ok := resolver.Priority(address[0]); !ok || priority != 1 {
+		t.Fatalf("got priority %d, %t, want 1", priority, ok)
+	}
+	if got := resolver.Zone(address[0]); got != "zone-a" {
+		t.Fatalf("got zone %q, want %q", got, "zone-a")
+	}
+	if got, want := resolver.Metadata(address[0]), map[string]string{"version": "v2"}; !maps.Equal(got, want) {
+		t.Fatalf("got metadata %v, want %v", got, want)
+	}
+	if _, ok := resolver.Weight(address[1]); ok {
+		t.Fatal("weight set for an address without one")
+	}
+
+	// JSON is YAML, unknown fields are rejected.
+	writeBackends(t, path, `{"addresses": [{"addr": "10.0.0.3:8080"}]}`, time.Now())
+	address, err = r.Resolve(resolver.ParseTarget("file://" + path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := addrsOf(address), []string{"10.0.0.3:8080"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	writeBackends(t, path, `{"addresses": [{"address": "10.0.0.3:8080"}]}`, time.Now())
+	if _, err := r.Resolve(resolver.ParseTarget("file://" + path)); err == nil {
+		t.Fatal("resolving a file with an unknown field succeeded")
+	}
+}
+
+func TestFileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	modTime := time.Now()
+	writeBackends(t, path, "addresses: [{addr: 10.0.0.1:8080}]", modTime)
+	r, err := resolver.Get("file").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ch, err := r.Watch(context.Background(), resolver.ParseTarget("file://"+path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive := func(want ...string) {
+		t.Helper()
+		select {
+		case address := <-ch:
+			if got := addrsOf(address); !slices.Equal(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no update received, want %v", want)
+		}
+	}
+	receive("10.0.0.1:8080")
+
+	modTime = modTime.Add(time.Second)
+	writeBackends(t, path, "addresses: [{addr: 10.0.0.1:8080}, {addr: 10.0.0.2:8080}]", modTime)
+	receive("10.0.0.1:8080", "10.0.0.2:8080")
+
+	// A broken file keeps the last addresses, a changed weight is an update.
+	modTime = modTime.Add(time.Second)
+	writeBackends(t, path, "addresses: [{addr: ", modTime)
+	modTime = modTime.Add(time.Second)
+	time.Sleep(1500 * time.Millisecond)
+	writeBackends(t, path, "addresses: [{addr: 10.0.0.1:8080}, {addr: 10.0.0.2:8080, weight: 2}]", modTime)
+	select {
+	case address := <-ch:
+		if weight, _ := resolver.Weight(address[1]); weight != 2 {
+			t.Fatalf("got weight %d, want 2", weight)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update received for the changed weight")
+	}
+}
@@ -0,0 +1,443 @@
+package resolver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// serviceAccountDir holds the credentials of the service account of a pod.
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// defaultNamespace is the namespace of services given without one.
+	defaultNamespace = "default"
+	// defaultWatchRetryInterval is the time to wait before a broken watch of
+	// the API server is restarted.
+	defaultWatchRetryInterval = time.Second
+)
+
+// K8sConfig configures how the k8s resolver reaches the API server.
+type K8sConfig struct {
+	// Host is the URL of the API server, e.g. https://10.96.0.1:443, or
+	// http://127.0.0.1:8001 for kubectl proxy.
+	Host string
+	// TokenFile is the file holding the bearer token of the requests. It is
+	// read for every request, tokens are rotated. Empty means no token.
+	TokenFile string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// InClusterK8sConfig returns the config reaching the API server with the
+// service account of the pod it runs in.
+func InClusterK8sConfig() (K8sConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return K8sConfig{}, errors.New("resolver: not running in a kubernetes cluster")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return K8sConfig{}, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return K8sConfig{}, errors.New("resolver: invalid service account CA")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return K8sConfig{
+		Host:      "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountDir + "/token",
+		Client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// k8sBuilder is the builder of the k8s resolver.
+type k8sBuilder struct {
+	// config is nil for the in-cluster config.
+	config *K8sConfig
+}
+
+// NewK8sBuilder returns a builder of k8s resolvers reaching the API server
+// as configured. The default one uses InClusterK8sConfig.
+func NewK8sBuilder(config K8sConfig) Builder {
+	return &k8sBuilder{config: &config}
+}
+
+// Build builds a k8s resolver.
+func (b *k8sBuilder) Build() (Resolver, error) {
+	config := b.config
+	if config == nil {
+		c, err := InClusterK8sConfig()
+		if err != nil {
+			return nil, err
+		}
+		config = &c
+	}
+	client := config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &k8sResolver{
+		host:      strings.TrimSuffix(config.Host, "/"),
+		tokenFile: config.TokenFile,
+		client:    client,
+		closed:    make(chan struct{}),
+	}, nil
+}
+
+// Scheme returns the scheme of the k8s resolver.
+func (b *k8sBuilder) Scheme() string {
+	return "k8s"
+}
+
+// k8sResolver resolves k8s:///service.namespace:port into the endpoints of
+// the service, as listed by its EndpointSlices.
+//
+// The port is the port of the endpoints. A port name is looked up in the
+// EndpointSlices, without port the first port of the endpoints is used.
+type k8sResolver struct {
+	host      string
+	tokenFile string
+	client    *http.Client
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// k8sTarget is a parsed target of the k8s resolver.
+type k8sTarget struct {
+	service   string
+	namespace string
+	port      string
+}
+
+// parseK8sTarget parses service[.namespace][:port].
+func parseK8sTarget(u url.URL) (k8sTarget, error) {
+	endpoint := Endpoint(u)
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host, port = endpoint, ""
+	}
+	service, namespace, _ := strings.Cut(host, ".")
+	if service == "" {
+		return k8sTarget{}, errors.New("resolver: empty service")
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return k8sTarget{service: service, namespace: namespace, port: port}, nil
+}
+
+// endpointSliceList is the part of a discovery.k8s.io/v1 EndpointSliceList
+// used by the resolver.
+type endpointSliceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []endpointSlice `json:"items"`
+}
+
+// endpointSlice is the part of a discovery.k8s.io/v1 EndpointSlice used by
+// the resolver.
+type endpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready       *bool `json:"ready"`
+			Serving     *bool `json:"serving"`
+			Terminating *bool `json:"terminating"`
+		} `json:"conditions"`
+		Zone  string `json:"zone"`
+		Hints *struct {
+			ForZones []struct {
+				Name string `json:"name"`
+			} `json:"forZones"`
+		} `json:"hints"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port *int32 `json:"port"`
+	} `json:"ports"`
+}
+
+// watchEvent is an event of a watch of the API server.
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// port returns the port of the endpoints of the slice for the target.
+func (es *endpointSlice) port(port string) (string, bool) {
+	if _, err := strconv.Atoi(port); err == nil {
+		return port, true
+	}
+	for _, p := range es.Ports {
+		if p.Port != nil && (port == "" || p.Name == port) {
+			return strconv.Itoa(int(*p.Port)), true
+		}
+	}
+	return "", false
+}
+
+// endpointAddresses returns the addresses of the endpoints of the slices.
+// Endpoints which are ready and not terminating are used. If there are none,
+// terminating endpoints which are still serving are used, so that calls keep
+// working while a service rolls over. The zone of the endpoints and their
+// zone hints are set as attributes.
+func endpointAddresses(t k8sTarget, endpointSlices map[string]*endpointSlice) []Address {
+	var ready, terminating []Address
+	for _, es := range endpointSlices {
+		port, ok := es.port(t.port)
+		if !ok {
+			continue
+		}
+		for _, ep := range es.Endpoints {
+			c := ep.Conditions
+			isReady := c.Ready == nil || *c.Ready
+			isServing := isReady
+			if c.Serving != nil {
+				isServing = *c.Serving
+			}
+			isTerminating := c.Terminating != nil && *c.Terminating
+			for _, ip := range ep.Addresses {
+				addr := Address{
+					Addr:       net.JoinHostPort(ip, port),
+					ServerName: t.service + "." + t.namespace,
+				}
+				if ep.Zone != "" {
+					addr = WithZone(addr, ep.Zone)
+				}
+				if ep.Hints != nil && len(ep.Hints.ForZones) > 0 {
+					var zones []string
+					for _, z := range ep.Hints.ForZones {
+						zones = append(zones, z.Name)
+					}
+					addr = WithZoneHints(addr, zones...)
+				}
+				switch {
+				case isReady && !isTerminating:
+					ready = append(ready, addr)
+				case isServing && isTerminating:
+					terminating = append(terminating, addr)
+				}
+			}
+		}
+	}
+	addrs := ready
+	if len(addrs) == 0 {
+		addrs = terminating
+	}
+	// The slices are kept in a map, order the addresses for a stable rotation.
+	slices.SortFunc(addrs, func(a, b Address) int {
+		return strings.Compare(a.Addr, b.Addr)
+	})
+	return addrs
+}
+
+// endpointSlicesPath returns the path of the EndpointSlices of the service.
+func (t k8sTarget) endpointSlicesPath() string {
+	return "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(t.namespace) + "/endpointslices"
+}
+
+// get sends a GET request for the path with the given query to the API server.
+func (k *k8sResolver) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.host+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("resolver: GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// list lists the EndpointSlices of the service. It returns them by name and
+// the resource version to watch from.
+func (k *k8sResolver) list(ctx context.Context, t k8sTarget) (map[string]*endpointSlice, string, error) {
+	resp, err := k.get(ctx, t.endpointSlicesPath(), url.Values{
+		"labelSelector": {"kubernetes.io/service-name=" + t.service},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var list endpointSliceList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", err
+	}
+	endpointSlices := make(map[string]*endpointSlice, len(list.Items))
+	for i := range list.Items {
+		endpointSlices[list.Items[i].Metadata.Name] = &list.Items[i]
+	}
+	return endpointSlices, list.Metadata.ResourceVersion, nil
+}
+
+// Resolve resolves the target into the addresses of the endpoints of the service.
+func (k *k8sResolver) Resolve(u url.URL) ([]Address, error) {
+	t, err := parseK8sTarget(u)
+	if err != nil {
+		return nil, err
+	}
+	endpointSlices, _, err := k.list(context.Background(), t)
+	if err != nil {
+		return nil, err
+	}
+	addrs := endpointAddresses(t, endpointSlices)
+	if len(addrs) == 0 {
+		return nil, errors.New("resolver: no addresses found")
+	}
+	return addrs, nil
+}
+
+// Watch watches the EndpointSlices of the service. The addresses are sent
+// whenever they change. A broken watch of the API server is restarted with a
+// new list of the EndpointSlices. The channel is closed when the context is
+// canceled or the resolver is closed.
+//
+// The first list of the EndpointSlices happens before Watch returns, its
+// error is returned.
+func (k *k8sResolver) Watch(ctx context.Context, u url.URL) (<-chan []Address, error) {
+	t, err := parseK8sTarget(u)
+	if err != nil {
+		return nil, err
+	}
+	endpointSlices, resourceVersion, err := k.list(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	last := endpointAddresses(t, endpointSlices)
+	ch := make(chan []Address, 1)
+	ch <- last
+	// Close cancels the requests in flight.
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-k.closed:
+			cancel()
+		}
+	}()
+	go func() {
+		defer cancel()
+		defer close(ch)
+		update := func() {
+			addrs := endpointAddresses(t, endpointSlices)
+			if addressesEqual(addrs, last) {
+				return
+			}
+			last = addrs
+			sendUpdate(ch, addrs)
+		}
+		for {
+			if resourceVersion == "" {
+				// The watch failed, start over from a new list.
+				timer := time.NewTimer(defaultWatchRetryInterval)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				s, rv, err := k.list(ctx, t)
+				if err != nil {
+					continue
+				}
+				endpointSlices, resourceVersion = s, rv
+				update()
+			}
+			resourceVersion = k.watch(ctx, t, resourceVersion, endpointSlices, update)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// watch watches the EndpointSlices of the service from the given resource
+// version, applying the events to endpointSlices and calling update after
+// each one. It returns the resource version to resume the watch from once it ends, or
+// an empty one if the EndpointSlices have to be listed again.
+func (k *k8sResolver) watch(ctx context.Context, t k8sTarget, resourceVersion string, endpointSlices map[string]*endpointSlice, update func()) string {
+	resp, err := k.get(ctx, t.endpointSlicesPath(), url.Values{
+		"labelSelector":       {"kubernetes.io/service-name=" + t.service},
+		"watch":               {"true"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+	})
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var event watchEvent
+		if err := dec.Decode(&event); err != nil {
+			// The API server ends watches after a while, resume it.
+			if errors.Is(err, io.EOF) {
+				return resourceVersion
+			}
+			return ""
+		}
+		var es endpointSlice
+		if err := json.Unmarshal(event.Object, &es); err != nil {
+			return ""
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			endpointSlices[es.Metadata.Name] = &es
+		case "DELETED":
+			delete(endpointSlices, es.Metadata.Name)
+		case "BOOKMARK":
+		default:
+			// An ERROR event, e.g. the resource version is too old.
+			return ""
+		}
+		resourceVersion = es.Metadata.ResourceVersion
+		update()
+	}
+}
+
+// ResolveNow does nothing, the EndpointSlices are watched.
+func (k *k8sResolver) ResolveNow() {}
+
+// Close stops the watches of the resolver, their channels are closed.
+func (k *k8sResolver) Close() error {
+	k.closeOnce.Do(func() {
+		close(k.closed)
+	})
+	return nil
+}
@@ -0,0 +1,178 @@
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/resolver"
+)
+
+// fakeAPIServer stands in for the API server, serving the EndpointSlices of
+// the echo service in the prod namespace.
+type fakeAPIServer struct {
+	list   string
+	events chan string
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/prod/endpointslices" {
+		http.NotFound(w, r)
+		return
+	}
+	if got := r.URL.Query().Get("labelSelector"); got != "kubernetes.io/service-name=echo" {
+		http.Error(w, "unexpected label selector "+got, http.StatusBadRequest)
+		return
+	}
+	if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Query().Get("watch") != "true" {
+		fmt.Fprint(w, s.list)
+		return
+	}
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case event := <-s.events:
+			fmt.Fprintln(w, event)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// endpointSlice returns an EndpointSlice of the echo service with the given endpoints.
+func endpointSlice(name, resourceVersion string, endpoints ...string) string {
+	return fmt.Sprintf(`{"metadata": {"name": %q, "resourceVersion": %q}, "addressType": "IPv4", "ports": [{"name": "grpc", "port": 8080}], "endpoints": [%s]}`,
+		name, resourceVersion, strings.Join(endpoints, ","))
+}
+
+func endpoint(ip string, ready, serving, terminating bool) string {
+	return fmt.Sprintf(`{"addresses": [%q], "conditions": {"ready": %t, "serving": %t, "terminating": %t}, "zone": "zone-a", "hints": {"forZones": [{"name": "zone-a"}]}}`,
+		ip, ready, serving, terminating)
+}
+
+func newK8sResolver(t *testing.T, s *fakeAPIServer) resolver.Resolver {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := resolver.NewK8sBuilder(resolver.K8sConfig{Host: srv.URL, TokenFile: token}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func addrsOf(address []resolver.Address) []string {
+	addrs := []string{}
+	for _, addr := range address {
+		addrs = append(addrs, addr.Addr)
+	}
+	return addrs
+}
+
+func TestK8sResolve(t *testing.T) {
+	s := &fakeAPIServer{
+		list: fmt.Sprintf(`{"metadata": {"resourceVersion": "1"}, "items": [%s]}`, endpointSlice("echo-1", "1",
+			endpoint("10.0.0.1", true, true, false),
+			endpoint("10.0.0.2", false, false, false),
+			endpoint("10.0.0.3", false, true, true),
+		)),
+	}
+	r := newK8sResolver(t, s)
+	for _, target := range []string{"k8s:///echo.prod:grpc", "k8s:///echo.prod:8080", "k8s:///echo.prod"} {
+		address, err := r.Resolve(resolver.ParseTarget(target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := addrsOf(address), []string{"10.0.0.1:8080"}; !slices.Equal(got, want) {
+			t.Fatalf("resolving %q got %v, want %v", target, got, want)
+		}
+		if got := resolver.Zone(address[0]); got != "zone-a" {
+			t.Fatalf("got zone %q, want %q", got, "zone-a")
+		}
+		if got, want := resolver.ZoneHints(address[0]), []string{"zone-a"}; !slices.Equal(got, want) {
+			t.Fatalf("got zone hints %v, want %v", got, want)
+		}
+	}
+	if _, err := r.Resolve(resolver.ParseTarget("k8s:///echo.staging:grpc")); err == nil {
+		t.Fatal("resolving a service of another namespace succeeded")
+	}
+}
+
+func TestK8sWatch(t *testing.T) {
+	s := &fakeAPIServer{
+		list: fmt.Sprintf(`{"metadata": {"resourceVersion": "1"}, "items": [%s]}`, endpointSlice("echo-1", "1",
+			endpoint("10.0.0.1", true, true, false),
+			endpoint("10.0.0.2", false, false, false),
+		)),
+		events: make(chan string),
+	}
+	r := newK8sResolver(t, s)
+	ch, err := r.Watch(context.Background(), resolver.ParseTarget("k8s:///echo.prod:grpc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive := func(want ...string) {
+		t.Helper()
+		select {
+		case address := <-ch:
+			if got := addrsOf(address); !slices.Equal(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no update received, want %v", want)
+		}
+	}
+	receive("10.0.0.1:8080")
+
+	// The second pod gets ready.
+	s.events <- fmt.Sprintf(`{"type": "MODIFIED", "object": %s}`, endpointSlice("echo-1", "2",
+		endpoint("10.0.0.1", true, true, false),
+		endpoint("10.0.0.2", true, true, false),
+	))
+	receive("10.0.0.1:8080", "10.0.0.2:8080")
+
+	// A bookmark changes nothing.
+	s.events <- `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "3"}}}`
+	// Another slice is added.
+	s.events <- fmt.Sprintf(`{"type": "ADDED", "object": %s}`, endpointSlice("echo-2", "4",
+		endpoint("10.0.1.1", true, true, false),
+	))
+	receive("10.0.0.1:8080", "10.0.0.2:8080", "10.0.1.1:8080")
+
+	// The slice is removed, then only terminating endpoints are left, those
+	// still serving are used.
+	s.events <- fmt.Sprintf(`{"type": "DELETED", "object": %s}`, endpointSlice("echo-2", "5"))
+	receive("10.0.0.1:8080", "10.0.0.2:8080")
+	s.events <- fmt.Sprintf(`{"type": "MODIFIED", "object": %s}`, endpointSlice("echo-1", "6",
+		endpoint("10.0.0.1", false, true, true),
+		endpoint("10.0.0.2", false, false, true),
+	))
+	receive("10.0.0.1:8080")
+
+	r.Close()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("update received after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed by Close")
+	}
+}
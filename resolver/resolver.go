@@ -1,3 +1,7 @@
+// Package resolver resolves the target of a client into the addresses of its
+// backends, with the resolver registered for the scheme of the target, see
+// ParseTarget. Registering a builder from e.g. NewDNSBuilder replaces the one
+// registered by default for the same scheme.
 package resolver
 
 import (
@@ -5,6 +9,7 @@ import (
 	"errors"
 	"net"
 	"net/url"
+	"strings"
 
 	"google.golang.org/grpc/resolver"
 )
@@ -16,24 +21,46 @@ type Address resolver.Address
 type Resolver interface {
 	Resolve(url.URL) ([]Address, error)
 	Watch(context.Context, url.URL) (<-chan []Address, error)
+	// ResolveNow asks the resolver to resolve the watched targets again, e.g.
+	// because a connection to one of their addresses failed. It is a hint,
+	// the resolver may ignore it.
+	ResolveNow()
 	Close() error
 }
 
 // dnsResolver is a resolver that resolves a target into a list of addresses using DNS.
-type dnsResolver struct{}
+type dnsResolver struct {
+	*poller
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+}
 
 // Resolve resolves a target into a list of addresses using DNS.
-func newDNSResolver() *dnsResolver {
-	return &dnsResolver{}
+func newDNSResolver(r *net.Resolver) *dnsResolver {
+	return &dnsResolver{
+		poller:     newPoller(defaultResolveInterval, defaultMinResolveInterval),
+		lookupHost: r.LookupHost,
+	}
 }
 
 // Resolve resolves a target into a list of addresses using DNS.
 func (d *dnsResolver) Resolve(u url.URL) ([]Address, error) {
-	hostName := u.Hostname()
+	return d.resolve(context.Background(), u)
+}
+
+func (d *dnsResolver) resolve(ctx context.Context, u url.URL) ([]Address, error) {
+	endpoint := Endpoint(u)
+	if endpoint == "" {
+		// dns://host:port as used before targets were parsed.
+		endpoint = u.Host
+	}
+	hostName, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		hostName, port = endpoint, ""
+	}
 	if hostName == "" {
 		return nil, errors.New("resolver: empty host")
 	}
-	ips, err := net.LookupHost(hostName)
+	ips, err := d.lookupHost(ctx, hostName)
 	if err != nil {
 		return nil, err
 	}
@@ -41,7 +68,7 @@ func (d *dnsResolver) Resolve(u url.URL) ([]Address, error) {
 	var addrs []Address
 	for _, ip := range ips {
 		addrs = append(addrs, Address{
-			Addr:       net.JoinHostPort(ip, u.Port()),
+			Addr:       net.JoinHostPort(ip, port),
 			ServerName: hostName,
 		})
 	}
@@ -52,14 +79,13 @@ func (d *dnsResolver) Resolve(u url.URL) ([]Address, error) {
 }
 
 // Watch watches for changes to the list of addresses for a target. It returns a channel that will receive updates to the list of addresses. The channel will be closed when the context is canceled or when the resolver is closed.
+//
+// The target is resolved again every 30 seconds, or sooner after ResolveNow
+// but at most every 5 seconds. Only changed addresses are sent.
 func (d *dnsResolver) Watch(ctx context.Context, u url.URL) (<-chan []Address, error) {
-	// TODO: Implement a watcher that periodically resolves the target and sends updates to the channel when the list of addresses changes.
-	return nil, nil
-}
-
-// Close closes the resolver and releases any resources associated with it.
-func (d *dnsResolver) Close() error {
-	return nil
+	return d.watch(ctx, func(ctx context.Context) ([]Address, error) {
+		return d.resolve(ctx, u)
+	})
 }
 
 // Builder is the interface for building a resolver.
@@ -68,27 +94,93 @@ type Builder interface {
 	Scheme() string
 }
 
-// GetResolver returns a resolver for the given scheme. If no resolver is registered for the scheme, it returns a DNS resolver.
+// dnsBuilder is the builder of the DNS resolver.
+type dnsBuilder struct {
+	resolver *net.Resolver
+}
+
+// NewDNSBuilder returns a builder of DNS resolvers looking up hosts with r.
+// The default one uses net.DefaultResolver.
+func NewDNSBuilder(r *net.Resolver) Builder {
+	return &dnsBuilder{resolver: r}
+}
+
+// Build builds a DNS resolver.
+func (b *dnsBuilder) Build() (Resolver, error) {
+	return newDNSResolver(b.resolver), nil
+}
+
+// Scheme returns the scheme of the DNS resolver.
+func (b *dnsBuilder) Scheme() string {
+	return "dns"
+}
+
+func init() {
+	Register(NewDNSBuilder(net.DefaultResolver))
+	Register(NewSRVBuilder(net.DefaultResolver))
+	Register(&staticBuilder{})
+	Register(&passthroughBuilder{})
+	Register(&unixBuilder{})
+	Register(&fileBuilder{})
+	Register(&k8sBuilder{})
+}
+
+// builders holds the registered resolvers by scheme.
+var builders = make(map[string]Builder)
+
+// Register registers the resolver builder under its scheme, which is case
+// insensitive. A builder registered later with the same scheme replaces the
+// earlier one.
+//
+// Register must only be called during initialization, e.g. from an init
+// function, it is not safe for concurrent use.
+func Register(b Builder) {
+	builders[strings.ToLower(b.Scheme())] = b
+}
+
+// Get returns the resolver builder registered with the given scheme. It
+// returns nil if no builder is registered with that scheme.
+func Get(scheme string) Builder {
+	return builders[strings.ToLower(scheme)]
+}
+
+// Build builds a resolver with the builder registered for the given scheme.
+// If no resolver is registered for the scheme, it returns a DNS resolver.
+func Build(scheme string) (Resolver, error) {
+	b := Get(scheme)
+	if b == nil {
+		return newDNSResolver(net.DefaultResolver), nil
+	}
+	return b.Build()
+}
+
+// GetResolver returns a resolver for the given scheme. If no resolver is registered for the scheme, or it cannot be built, it returns a DNS resolver.
+//
+// Deprecated: Use Build, which reports why the resolver cannot be built.
 func GetResolver(scheme string) Resolver {
-	return newDNSResolver()
-}
-
-// func addressesEqual(a, b []Address) bool {
-// 	if len(a) != len(b) {
-// 		return false
-// 	}
-// 	as := make([]string, len(a))
-// 	bs := make([]string, len(b))
-// 	for i := range a {
-// 		as[i] = a[i].Addr
-// 		bs[i] = b[i].Addr
-// 	}
-// 	sort.Strings(as)
-// 	sort.Strings(bs)
-// 	for i := range as {
-// 		if as[i] != bs[i] {
-// 			return false
-// 		}
-// 	}
-// 	return true
-// }
+	if r, err := Build(scheme); err == nil {
+		return r
+	}
+	return newDNSResolver(net.DefaultResolver)
+}
+
+// ParseTarget parses a dial target of the form scheme://[authority]/endpoint,
+// e.g. dns:///svc:8080, dns+srv:///_grpc._tcp.svc, static:///a:1,b:2,
+// passthrough:///host:port, unix:///path or file:///path. Targets without
+// the scheme of a registered resolver, like host:port, are resolved using DNS.
+func ParseTarget(target string) url.URL {
+	u, err := url.Parse(target)
+	if err == nil && u.Scheme != "" && Get(u.Scheme) != nil {
+		return *u
+	}
+	return url.URL{Scheme: "dns", Path: "/" + target}
+}
+
+// Endpoint returns the endpoint of a parsed target, the part following the
+// authority.
+func Endpoint(u url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
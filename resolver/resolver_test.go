@@ -2,13 +2,17 @@ package resolver_test
 
 import (
 	"net/url"
+	"slices"
 	"testing"
 
 	"github.com/vimcoders/grpcx/resolver"
 )
 
 func TestResolver(t *testing.T) {
-	res := resolver.GetResolver("")
+	res, err := resolver.Build("")
+	if err != nil {
+		t.Fatal(err)
+	}
 	u := url.URL{Scheme: "dns", Host: "127.0.0.1:50002"}
 	address, err := res.Resolve(u)
 	if err != nil {
@@ -17,3 +21,56 @@ func TestResolver(t *testing.T) {
 	}
 	t.Log(address)
 }
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		scheme   string
+		endpoint string
+	}{
+		{target: "dns:///svc:8080", scheme: "dns", endpoint: "svc:8080"},
+		{target: "svc:8080", scheme: "dns", endpoint: "svc:8080"},
+		{target: "127.0.0.1:8080", scheme: "dns", endpoint: "127.0.0.1:8080"},
+		{target: "static:///a:1,b:2", scheme: "static", endpoint: "a:1,b:2"},
+		{target: "passthrough:///host:port", scheme: "passthrough", endpoint: "host:port"},
+		{target: "unix:///tmp/grpcx.sock", scheme: "unix", endpoint: "tmp/grpcx.sock"},
+		{target: "unknown:///svc:8080", scheme: "dns", endpoint: "unknown:///svc:8080"},
+	}
+	for _, tt := range tests {
+		u := resolver.ParseTarget(tt.target)
+		if u.Scheme != tt.scheme || resolver.Endpoint(u) != tt.endpoint {
+			t.Fatalf("ParseTarget(%q) = %q, %q, want %q, %q", tt.target, u.Scheme, resolver.Endpoint(u), tt.scheme, tt.endpoint)
+		}
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{target: "dns:///127.0.0.1:8080", want: []string{"127.0.0.1:8080"}},
+		{target: "static:///127.0.0.1:1, 127.0.0.2:2", want: []string{"127.0.0.1:1", "127.0.0.2:2"}},
+		{target: "passthrough:///svc.example:8080", want: []string{"svc.example:8080"}},
+		{target: "unix:///tmp/grpcx.sock", want: []string{"unix:/tmp/grpcx.sock"}},
+		{target: "unix:grpcx.sock", want: []string{"unix:grpcx.sock"}},
+	}
+	for _, tt := range tests {
+		u := resolver.ParseTarget(tt.target)
+		r, err := resolver.Build(u.Scheme)
+		if err != nil {
+			t.Fatalf("building the resolver of %q: %v", tt.target, err)
+		}
+		address, err := r.Resolve(u)
+		if err != nil {
+			t.Fatalf("resolving %q: %v", tt.target, err)
+		}
+		var got []string
+		for _, addr := range address {
+			got = append(got, addr.Addr)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("resolving %q got %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// srvBuilder is the builder of the DNS SRV resolver.
+type srvBuilder struct {
+	resolver *net.Resolver
+}
+
+// NewSRVBuilder returns a builder of DNS SRV resolvers looking up records
+// with r. The default one uses net.DefaultResolver.
+func NewSRVBuilder(r *net.Resolver) Builder {
+	return &srvBuilder{resolver: r}
+}
+
+// Build builds a DNS SRV resolver.
+func (b *srvBuilder) Build() (Resolver, error) {
+	return &srvResolver{
+		poller:   newPoller(defaultResolveInterval, defaultMinResolveInterval),
+		resolver: b.resolver,
+	}, nil
+}
+
+// Scheme returns the scheme of the DNS SRV resolver.
+func (b *srvBuilder) Scheme() string {
+	return "dns+srv"
+}
+
+// srvResolver resolves dns+srv:///_service._proto.name into the addresses of
+// the targets of its SRV records. The priority and weight of the records are
+// set as attributes of the addresses, see Priority and Weight.
+type srvResolver struct {
+	*poller
+	resolver *net.Resolver
+}
+
+// Resolve resolves a target into a list of addresses using DNS SRV records.
+func (r *srvResolver) Resolve(u url.URL) ([]Address, error) {
+	return r.resolve(context.Background(), u)
+}
+
+func (r *srvResolver) resolve(ctx context.Context, u url.URL) ([]Address, error) {
+	name := Endpoint(u)
+	if name == "" {
+		return nil, errors.New("resolver: empty name")
+	}
+	_, records, err := r.resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	// The records are ordered by priority and shuffled by weight.
+	var addrs []Address
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		ips, err := r.resolver.LookupHost(ctx, host)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			addr := Address{
+				Addr:       net.JoinHostPort(ip, strconv.Itoa(int(record.Port))),
+				ServerName: host,
+			}
+			addr = WithPriority(addr, uint32(record.Priority))
+			addr = WithWeight(addr, uint32(record.Weight))
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("resolver: no addresses found")
+	}
+	return addrs, nil
+}
+
+// Watch watches for changes to the addresses of the SRV records of a target,
+// as the DNS resolver does.
+func (r *srvResolver) Watch(ctx context.Context, u url.URL) (<-chan []Address, error) {
+	return r.watch(ctx, func(ctx context.Context) ([]Address, error) {
+		return r.resolve(ctx, u)
+	})
+}
@@ -0,0 +1,153 @@
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/vimcoders/grpcx/resolver"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsStub answers DNS queries over UDP from its records.
+type dnsStub struct {
+	conn net.PacketConn
+	srv  map[string][]dnsmessage.SRVResource
+	a    map[string][4]byte
+}
+
+// newDNSStub starts a DNS stub serving the SRV records of
+// _grpc._tcp.echo.test and returns a resolver querying it.
+func newDNSStub(t *testing.T) *net.Resolver {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s := &dnsStub{
+		conn: conn,
+		srv: map[string][]dnsmessage.SRVResource{
+			"_grpc._tcp.echo.test.": {
+				{Priority: 10, Weight: 60, Port: 8080, Target: dnsmessage.MustNewName("a.echo.test.")},
+				{Priority: 10, Weight: 40, Port: 8081, Target: dnsmessage.MustNewName("b.echo.test.")},
+				{Priority: 20, Weight: 0, Port: 8082, Target: dnsmessage.MustNewName("c.echo.test.")},
+			},
+		},
+		a: map[string][4]byte{
+			"a.echo.test.": {10, 0, 0, 1},
+			"b.echo.test.": {10, 0, 0, 2},
+			"c.echo.test.": {10, 0, 0, 3},
+		},
+	}
+	go s.serve()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *dnsStub) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+			continue
+		}
+		resp := s.answer(query)
+		b, err := resp.Pack()
+		if err != nil {
+			continue
+		}
+		_, _ = s.conn.WriteTo(b, addr)
+	}
+}
+
+func (s *dnsStub) answer(query dnsmessage.Message) dnsmessage.Message {
+	q := query.Questions[0]
+	resp := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:            query.ID,
+			Response:      true,
+			Authoritative: true,
+			RCode:         dnsmessage.RCodeSuccess,
+		},
+		Questions: query.Questions,
+	}
+	name := q.Name.String()
+	_, isSRV := s.srv[name]
+	_, isA := s.a[name]
+	if !isSRV && !isA {
+		resp.RCode = dnsmessage.RCodeNameError
+		return resp
+	}
+	header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+	switch q.Type {
+	case dnsmessage.TypeSRV:
+		for _, srv := range s.srv[name] {
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &srv})
+		}
+	case dnsmessage.TypeA:
+		if ip, ok := s.a[name]; ok {
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: ip}})
+		}
+	}
+	return resp
+}
+
+func TestSRVResolve(t *testing.T) {
+	r, err := resolver.NewSRVBuilder(newDNSStub(t)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	address, err := r.Resolve(resolver.ParseTarget("dns+srv:///_grpc._tcp.echo.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type srv struct {
+		addr     string
+		priority uint32
+		weight   uint32
+	}
+	var got []srv
+	for _, addr := range address {
+		priority, ok := resolver.Priority(addr)
+		if !ok {
+			t.Fatalf("%v has no priority", addr.Addr)
+		}
+		weight, ok := resolver.Weight(addr)
+		if !ok {
+			t.Fatalf("%v has no weight", addr.Addr)
+		}
+		got = append(got, srv{addr.Addr, priority, weight})
+	}
+	// Records of the same priority are shuffled by weight.
+	slices.SortFunc(got, func(a, b srv) int {
+		return int(a.priority) - int(b.priority)
+	})
+	slices.SortStableFunc(got[:2], func(a, b srv) int {
+		return int(b.weight) - int(a.weight)
+	})
+	want := []srv{
+		{"10.0.0.1:8080", 10, 60},
+		{"10.0.0.2:8081", 10, 40},
+		{"10.0.0.3:8082", 20, 0},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if _, err := r.Resolve(resolver.ParseTarget("dns+srv:///_grpc._tcp.unknown.test")); err == nil {
+		t.Fatal("resolving an unknown name succeeded")
+	}
+}
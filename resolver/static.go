@@ -0,0 +1,118 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// staticBuilder is the builder of the static resolver.
+type staticBuilder struct{}
+
+// Build builds a static resolver.
+func (b *staticBuilder) Build() (Resolver, error) {
+	return &fixedResolver{resolve: resolveStatic}, nil
+}
+
+// Scheme returns the scheme of the static resolver.
+func (b *staticBuilder) Scheme() string {
+	return "static"
+}
+
+// resolveStatic resolves static:///a:1,b:2 into the addresses listed.
+func resolveStatic(u url.URL) ([]Address, error) {
+	var addrs []Address
+	for addr := range strings.SplitSeq(Endpoint(u), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, Address{Addr: addr})
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("resolver: no addresses found")
+	}
+	return addrs, nil
+}
+
+// passthroughBuilder is the builder of the passthrough resolver.
+type passthroughBuilder struct{}
+
+// Build builds a passthrough resolver.
+func (b *passthroughBuilder) Build() (Resolver, error) {
+	return &fixedResolver{resolve: resolvePassthrough}, nil
+}
+
+// Scheme returns the scheme of the passthrough resolver.
+func (b *passthroughBuilder) Scheme() string {
+	return "passthrough"
+}
+
+// resolvePassthrough resolves passthrough:///host:port into the endpoint as
+// is, the host is looked up when it is dialed.
+func resolvePassthrough(u url.URL) ([]Address, error) {
+	endpoint := Endpoint(u)
+	if endpoint == "" {
+		return nil, errors.New("resolver: empty endpoint")
+	}
+	return []Address{{Addr: endpoint}}, nil
+}
+
+// unixBuilder is the builder of the unix socket resolver.
+type unixBuilder struct{}
+
+// Build builds a unix socket resolver.
+func (b *unixBuilder) Build() (Resolver, error) {
+	return &fixedResolver{resolve: resolveUnix}, nil
+}
+
+// Scheme returns the scheme of the unix socket resolver.
+func (b *unixBuilder) Scheme() string {
+	return "unix"
+}
+
+// resolveUnix resolves unix:///path, or unix:path for a relative path, into
+// the address of the socket, which roundtrip dials as a unix socket.
+func resolveUnix(u url.URL) ([]Address, error) {
+	path := u.Path
+	if u.Opaque != "" {
+		path = u.Opaque
+	}
+	if path == "" {
+		return nil, errors.New("resolver: empty path")
+	}
+	return []Address{{Addr: "unix:" + path}}, nil
+}
+
+// fixedResolver is a resolver whose addresses never change.
+type fixedResolver struct {
+	resolve func(url.URL) ([]Address, error)
+}
+
+// Resolve resolves the target into its addresses.
+func (r *fixedResolver) Resolve(u url.URL) ([]Address, error) {
+	return r.resolve(u)
+}
+
+// Watch sends the addresses of the target once, the channel is closed when
+// the context is canceled.
+func (r *fixedResolver) Watch(ctx context.Context, u url.URL) (<-chan []Address, error) {
+	addrs, err := r.resolve(u)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Address, 1)
+	ch <- addrs
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// ResolveNow does nothing, the addresses never change.
+func (r *fixedResolver) ResolveNow() {}
+
+// Close closes the resolver.
+func (r *fixedResolver) Close() error {
+	return nil
+}
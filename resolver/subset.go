@@ -0,0 +1,112 @@
+package resolver
+
+import (
+	"cmp"
+	"context"
+	"net/url"
+	"slices"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// subsetBuilder builds resolvers keeping a subset of the addresses of the
+// resolvers of another builder.
+type subsetBuilder struct {
+	Builder
+	clientID string
+	size     int
+}
+
+// NewSubsetBuilder returns a builder of the resolvers of b, under the same
+// scheme, keeping a subset of size addresses for the client, see Subset.
+// Register it to replace b for every client of the process, e.g.
+//
+//	resolver.Register(resolver.NewSubsetBuilder(resolver.Get("k8s"), os.Getenv("POD_NAME"), 10))
+//
+// See balancer.WithSubset to keep a subset for a single client.
+func NewSubsetBuilder(b Builder, clientID string, size int) Builder {
+	return &subsetBuilder{Builder: b, clientID: clientID, size: size}
+}
+
+// Build builds a resolver of the wrapped builder keeping a subset.
+func (b *subsetBuilder) Build() (Resolver, error) {
+	r, err := b.Builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return Subset(r, b.clientID, b.size), nil
+}
+
+// subsetResolver keeps a subset of the addresses of a resolver.
+type subsetResolver struct {
+	Resolver
+	clientID string
+	size     int
+}
+
+// Subset returns a resolver keeping a subset of size of the addresses of r,
+// so that a client connects to size backends instead of all of them. A size
+// of zero or less keeps all the addresses.
+//
+// The subset is chosen by rendezvous hashing of the addresses with the ID of
+// the client: it is the same for the same client ID whatever the order of
+// the addresses, different client IDs spread over the backends, and when an
+// address comes or goes at most one address of the subset changes.
+func Subset(r Resolver, clientID string, size int) Resolver {
+	return &subsetResolver{Resolver: r, clientID: clientID, size: size}
+}
+
+// Resolve resolves a target into the subset of its addresses.
+func (r *subsetResolver) Resolve(u url.URL) ([]Address, error) {
+	address, err := r.Resolver.Resolve(u)
+	if err != nil {
+		return nil, err
+	}
+	return r.subset(address), nil
+}
+
+// Watch watches the subset of the addresses of a target, it is sent again
+// whenever it changes.
+func (r *subsetResolver) Watch(ctx context.Context, u url.URL) (<-chan []Address, error) {
+	updates, err := r.Resolver.Watch(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Address, 1)
+	go func() {
+		defer close(ch)
+		var last []Address
+		for address := range updates {
+			subset := r.subset(address)
+			if last != nil && addressesEqual(subset, last) {
+				continue
+			}
+			last = subset
+			sendUpdate(ch, subset)
+		}
+	}()
+	return ch, nil
+}
+
+// subset returns the size addresses ranking first for the client.
+func (r *subsetResolver) subset(address []Address) []Address {
+	if r.size <= 0 || len(address) <= r.size {
+		return address
+	}
+	type ranked struct {
+		score uint64
+		addr  Address
+	}
+	ranks := make([]ranked, len(address))
+	for i, addr := range address {
+		ranks[i] = ranked{score: xxhash.Sum64String(r.clientID + "/" + addr.Addr), addr: addr}
+	}
+	slices.SortFunc(ranks, func(a, b ranked) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(a.addr.Addr, b.addr.Addr))
+	})
+	subset := make([]Address, r.size)
+	for i := range subset {
+		subset[i] = ranks[i].addr
+	}
+	return subset
+}
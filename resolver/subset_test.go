@@ -0,0 +1,156 @@
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/resolver"
+)
+
+// listResolver resolves any target into its addresses, its watch sends
+// those pushed.
+type listResolver struct {
+	addrs   []string
+	updates chan []resolver.Address
+}
+
+func addressesOf(addrs ...string) []resolver.Address {
+	var address []resolver.Address
+	for _, addr := range addrs {
+		address = append(address, resolver.Address{Addr: addr})
+	}
+	return address
+}
+
+func (r *listResolver) Resolve(u url.URL) ([]resolver.Address, error) {
+	return addressesOf(r.addrs...), nil
+}
+
+func (r *listResolver) Watch(ctx context.Context, u url.URL) (<-chan []resolver.Address, error) {
+	return r.updates, nil
+}
+
+func (r *listResolver) ResolveNow() {}
+
+func (r *listResolver) Close() error {
+	return nil
+}
+
+// backendAddrs returns the addresses of n backends.
+func backendAddrs(n int) []string {
+	var addrs []string
+	for i := range n {
+		addrs = append(addrs, fmt.Sprintf("10.0.%d.%d:8080", i/256, i%256))
+	}
+	return addrs
+}
+
+// subsetOf returns the sorted subset of size of addrs for the client.
+func subsetOf(t *testing.T, clientID string, size int, addrs []string) []string {
+	t.Helper()
+	address, err := resolver.Subset(&listResolver{addrs: addrs}, clientID, size).Resolve(url.URL{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subset := addrsOf(address)
+	slices.Sort(subset)
+	return subset
+}
+
+func TestSubset(t *testing.T) {
+	addrs := backendAddrs(50)
+	subset := subsetOf(t, "client-1", 5, addrs)
+	if len(subset) != 5 {
+		t.Fatalf("got subset %v, want 5 addresses", subset)
+	}
+	// The subset does not depend on the order of the addresses.
+	reversed := slices.Clone(addrs)
+	slices.Reverse(reversed)
+	if got := subsetOf(t, "client-1", 5, reversed); !slices.Equal(got, subset) {
+		t.Fatalf("got subset %v of reversed addresses, want %v", got, subset)
+	}
+	// Removing an address out of the subset changes nothing, removing one of
+	// the subset replaces only it.
+	outside := slices.IndexFunc(addrs, func(addr string) bool { return !slices.Contains(subset, addr) })
+	if got := subsetOf(t, "client-1", 5, slices.Delete(slices.Clone(addrs), outside, outside+1)); !slices.Equal(got, subset) {
+		t.Fatalf("got subset %v after removing %v, want %v", got, addrs[outside], subset)
+	}
+	got := subsetOf(t, "client-1", 5, slices.DeleteFunc(slices.Clone(addrs), func(addr string) bool { return addr == subset[0] }))
+	var kept int
+	for _, addr := range got {
+		if slices.Contains(subset, addr) {
+			kept++
+		}
+	}
+	if kept != 4 {
+		t.Fatalf("got subset %v after removing %v from %v, want 4 addresses kept", got, subset[0], subset)
+	}
+	// Fewer addresses than the size are all kept.
+	if got := subsetOf(t, "client-1", 5, addrs[:3]); len(got) != 3 {
+		t.Fatalf("got subset %v, want all 3 addresses", got)
+	}
+}
+
+func TestSubsetSpread(t *testing.T) {
+	addrs := backendAddrs(20)
+	clients := make(map[string]int)
+	for i := range 200 {
+		for _, addr := range subsetOf(t, fmt.Sprintf("client-%d", i), 5, addrs) {
+			clients[addr]++
+		}
+	}
+	// Each backend has 50 clients on average.
+	for _, addr := range addrs {
+		if n := clients[addr]; n < 25 || n > 75 {
+			t.Fatalf("%v has %d clients, want about 50", addr, n)
+		}
+	}
+}
+
+func TestSubsetWatch(t *testing.T) {
+	addrs := backendAddrs(10)
+	inner := &listResolver{updates: make(chan []resolver.Address, 1)}
+	r := resolver.Subset(inner, "client-1", 3)
+	ch, err := r.Watch(context.Background(), url.URL{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive := func() []string {
+		t.Helper()
+		select {
+		case address := <-ch:
+			subset := addrsOf(address)
+			slices.Sort(subset)
+			return subset
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update received")
+			return nil
+		}
+	}
+	inner.updates <- addressesOf(addrs...)
+	subset := receive()
+	if len(subset) != 3 {
+		t.Fatalf("got subset %v, want 3 addresses", subset)
+	}
+	// An update leaving the subset as it is is not sent.
+	var outside string
+	for _, addr := range addrs {
+		if !slices.Contains(subset, addr) {
+			outside = addr
+			break
+		}
+	}
+	inner.updates <- addressesOf(slices.DeleteFunc(slices.Clone(addrs), func(addr string) bool { return addr == outside })...)
+	inner.updates <- addressesOf(slices.DeleteFunc(slices.Clone(addrs), func(addr string) bool { return addr == subset[0] })...)
+	if got := receive(); len(got) != 3 || slices.Contains(got, subset[0]) {
+		t.Fatalf("got subset %v, want 3 addresses without %v", got, subset[0])
+	}
+	close(inner.updates)
+	if _, ok := <-ch; ok {
+		t.Fatal("channel not closed with the watched one")
+	}
+}
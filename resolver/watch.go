@@ -0,0 +1,150 @@
+package resolver
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// defaultResolveInterval is the default interval between two resolutions of a watched target.
+	defaultResolveInterval = 30 * time.Second
+	// defaultMinResolveInterval is the default minimum interval between two
+	// resolutions of a watched target, it rate limits ResolveNow.
+	defaultMinResolveInterval = 5 * time.Second
+)
+
+// poller watches targets by resolving them periodically. Resolvers without
+// a way to be notified of changes embed it.
+type poller struct {
+	interval    time.Duration
+	minInterval time.Duration
+
+	mu sync.Mutex
+	// now is closed and replaced by ResolveNow.
+	now       chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newPoller creates a poller resolving every interval, but not more often
+// than every minInterval.
+func newPoller(interval, minInterval time.Duration) *poller {
+	return &poller{
+		interval:    interval,
+		minInterval: minInterval,
+		now:         make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// watch resolves the target with resolve until ctx is done or the poller is
+// closed. The addresses are sent on the returned channel whenever they
+// change, an update not received yet is replaced by a newer one. Failed
+// resolutions keep the last addresses.
+//
+// The first resolution happens before watch returns, its error is returned.
+func (p *poller) watch(ctx context.Context, resolve func(context.Context) ([]Address, error)) (<-chan []Address, error) {
+	now := p.resolveNow()
+	addrs, err := resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Address, 1)
+	ch <- addrs
+	go func() {
+		defer close(ch)
+		last, lastResolved := addrs, time.Now()
+		for {
+			timer := time.NewTimer(p.interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-p.closed:
+				timer.Stop()
+				return
+			case <-timer.C:
+			case <-now:
+				timer.Stop()
+			}
+			// Rate limit resolutions asked for by ResolveNow.
+			if wait := time.Until(lastResolved.Add(p.minInterval)); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-p.closed:
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+			now = p.resolveNow()
+			addrs, err := resolve(ctx)
+			lastResolved = time.Now()
+			if err != nil || addressesEqual(addrs, last) {
+				continue
+			}
+			last = addrs
+			sendUpdate(ch, addrs)
+		}
+	}()
+	return ch, nil
+}
+
+// sendUpdate sends the addresses on a watch channel with a buffer of one. An
+// update which was not received yet is dropped, it is stale.
+func sendUpdate(ch chan []Address, addrs []Address) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- addrs
+}
+
+// resolveNow returns the channel closed by the next call to ResolveNow.
+func (p *poller) resolveNow() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.now
+}
+
+// ResolveNow asks the watches of the poller to resolve their target again
+// as soon as the minimum interval allows.
+func (p *poller) ResolveNow() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	close(p.now)
+	p.now = make(chan struct{})
+}
+
+// Close stops the watches of the poller, their channels are closed.
+func (p *poller) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+// addressesEqual reports whether a and b hold the same addresses with the
+// same attributes, in any order.
+func addressesEqual(a, b []Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byAddr := func(x, y Address) int {
+		return strings.Compare(x.Addr, y.Addr)
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.SortFunc(a, byAddr)
+	slices.SortFunc(b, byAddr)
+	return slices.EqualFunc(a, b, func(x, y Address) bool {
+		return resolver.Address(x).Equal(resolver.Address(y))
+	})
+}
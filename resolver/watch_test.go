@@ -0,0 +1,122 @@
+package resolver
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeLookup answers DNS lookups with the hosts it was last given, counting
+// the lookups.
+type fakeLookup struct {
+	sync.Mutex
+	hosts   []string
+	lookups atomic.Int32
+}
+
+func (f *fakeLookup) set(hosts ...string) {
+	f.Lock()
+	defer f.Unlock()
+	f.hosts = hosts
+}
+
+func (f *fakeLookup) lookupHost(ctx context.Context, host string) ([]string, error) {
+	f.lookups.Add(1)
+	f.Lock()
+	defer f.Unlock()
+	return f.hosts, nil
+}
+
+func addrsOf(address []Address) []string {
+	var addrs []string
+	for _, addr := range address {
+		addrs = append(addrs, addr.Addr)
+	}
+	return addrs
+}
+
+func receive(t *testing.T, ch <-chan []Address) []string {
+	t.Helper()
+	select {
+	case address := <-ch:
+		return addrsOf(address)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update received")
+		return nil
+	}
+}
+
+func TestDNSWatch(t *testing.T) {
+	f := &fakeLookup{}
+	f.set("10.0.0.1", "10.0.0.2")
+	d := &dnsResolver{
+		poller:     newPoller(20*time.Millisecond, 0),
+		lookupHost: f.lookupHost,
+	}
+	ch, err := d.Watch(context.Background(), url.URL{Scheme: "dns", Path: "/svc:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := receive(t, ch), []string{"10.0.0.1:8080", "10.0.0.2:8080"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Resolutions of the same addresses are not sent.
+	for n := f.lookups.Load(); f.lookups.Load() < n+3; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case address := <-ch:
+		t.Fatalf("unchanged addresses sent: %v", addrsOf(address))
+	default:
+	}
+	f.set("10.0.0.2", "10.0.0.1")
+	f.set("10.0.0.3")
+	if got, want := receive(t, ch), []string{"10.0.0.3:8080"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	d.Close()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("update received after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed by Close")
+	}
+}
+
+func TestDNSResolveNow(t *testing.T) {
+	f := &fakeLookup{}
+	f.set("10.0.0.1")
+	d := &dnsResolver{
+		poller:     newPoller(time.Hour, 200*time.Millisecond),
+		lookupHost: f.lookupHost,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := d.Watch(ctx, url.URL{Scheme: "dns", Path: "/svc:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, ch)
+	// The interval is an hour, only ResolveNow leads to a resolution.
+	f.set("10.0.0.2")
+	d.ResolveNow()
+	if got, want := receive(t, ch), []string{"10.0.0.2:8080"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// ResolveNow is rate limited.
+	f.set("10.0.0.3")
+	start := time.Now()
+	d.ResolveNow()
+	receive(t, ch)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("ResolveNow not rate limited, resolved after %v", elapsed)
+	}
+	cancel()
+	for range ch {
+	}
+}
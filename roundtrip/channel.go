@@ -19,88 +19,257 @@ package roundtrip
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"io"
 	"math"
 	"net"
 	"sync"
 
 	"github.com/vimcoders/grpcx/status"
+
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	messageHeaderLength = 10
+	// messageLengthMax is the maximum length of a single frame, larger
+	// messages are split into fragments.
+	messageLengthMax = math.MaxUint16
+	// defaultMaxRecvMsgSize is the default maximum size of a received message.
+	defaultMaxRecvMsgSize = 1024 * 1024 * 4
+	// defaultMaxSendMsgSize is the default maximum size of a sent message.
+	defaultMaxSendMsgSize = math.MaxInt32
+	// maxFragmentedStreams is the maximum number of messages being
+	// reassembled from their fragments at once on a connection.
+	maxFragmentedStreams = 1024
+)
+
+// errTooManyFragmented is returned by Recv when the peer sends fragments of
+// more than maxFragmentedStreams messages at once, the connection is closed.
+var errTooManyFragmented = errors.New("roundtrip: too many fragmented messages")
+
+type messageType uint8
+
+const (
+	// messageTypeRequest opens a stream and carries an api.Request.
+	messageTypeRequest messageType = 0x1
+	// messageTypeResponse carries an api.Response and ends a stream.
+	messageTypeResponse messageType = 0x2
+	// messageTypeData carries a single encoded message of a streaming call.
+	messageTypeData messageType = 0x3
+	// messageTypeHeader carries an api.Response holding only the header
+	// metadata of a streaming call, it is sent before the first data message.
+	messageTypeHeader messageType = 0x4
+	// messageTypeCancel aborts a call, the client sends it when it stops
+	// waiting for the response so the server cancels the handler.
+	messageTypeCancel messageType = 0x5
+	// messageTypeWindowUpdate grants the peer more bytes of data messages on
+	// a stream, its payload is the increment as a big endian uint32.
+	messageTypeWindowUpdate messageType = 0x6
 )
 
 const (
-	messageHeaderLength = 8
-	messageLengthMax    = math.MaxUint16
+	// flagRemoteClosed indicates that the sender will not send any more
+	// messages on the stream (half-close).
+	flagRemoteClosed uint8 = 0x1
+	// flagRemoteOpen indicates that the stream stays open for data messages
+	// after the request.
+	flagRemoteOpen uint8 = 0x2
+	// flagNoData indicates that the message carries no payload.
+	flagNoData uint8 = 0x4
+	// flagFragment indicates that more fragments of the message follow. The
+	// last fragment carries the flags of the message.
+	flagFragment uint8 = 0x8
 )
 
+// messageHeader represents the fixed-length message header of 10 bytes sent
+// with every request.
+type messageHeader struct {
+	Length   uint32      // length excluding this header. b[:4]
+	StreamID uint32      // identifies which request stream message is a part of. b[4:8]
+	Type     messageType // message type b[8]
+	Flags    uint8       // type specific flags b[9]
+}
+
 // Sender is the interface for sending messages to a channel.
 type Sender interface {
-	Send(uint32, []byte) error
+	Send(streamID uint32, t messageType, flags uint8, p []byte) error
 }
 
-// messageHeader represents the fixed-length message header of 10 bytes sent
-// with every request.
 var buffers sync.Pool
 
 // channel is a wrapper around a net.Conn that provides methods for sending and receiving messages with a fixed-length header.
 type channel struct {
 	net.Conn
 	br *bufio.Reader
+	// wmu serializes writers, so a frame is never interleaved with another.
+	wmu            sync.Mutex
+	maxRecvMsgSize int
+	maxSendMsgSize int
+	// fragments holds the partially received messages by stream ID, pending
+	// is the number of bytes they hold. They are only accessed by the
+	// goroutine calling Recv.
+	fragments map[uint32]*fragment
+	pending   int
+}
+
+// fragment is a message being reassembled from its fragments.
+type fragment struct {
+	buf []byte
+	// size is the number of bytes received of the message so far.
+	size int
+	// discard is set once the message exceeds the maximum size, or the
+	// messages being reassembled on the connection together do, its
+	// remaining fragments are dropped.
+	discard bool
 }
 
-// NewChannel creates a new channel with the given net.Conn.
-func newChannel(conn net.Conn) *channel {
+// NewChannel creates a new channel with the given net.Conn and message size limits.
+func newChannel(conn net.Conn, maxRecvMsgSize, maxSendMsgSize int) *channel {
 	return &channel{
-		Conn: conn,
-		br:   bufio.NewReader(conn),
+		Conn:           conn,
+		br:             bufio.NewReader(conn),
+		maxRecvMsgSize: maxRecvMsgSize,
+		maxSendMsgSize: maxSendMsgSize,
+		fragments:      make(map[uint32]*fragment),
 	}
 }
 
-// recv a message from the channel. The returned buffer contains the message.
+// Recv receives a message from the channel, reassembling it if it was sent in
+// fragments. The returned buffer contains the message.
 //
 // If a valid grpc status is returned, the message header
 // returned will be valid and caller should send that along to
 // the correct consumer. The bytes on the underlying channel
 // will be discarded.
-func (ch *channel) Recv() (uint32, []byte, error) {
+func (ch *channel) Recv() (messageHeader, []byte, error) {
+	for {
+		mh, p, err := ch.recv()
+		if err != nil {
+			return mh, nil, err
+		}
+		f, ok := ch.fragments[mh.StreamID]
+		if mh.Flags&flagFragment != 0 {
+			if !ok {
+				if len(ch.fragments) >= maxFragmentedStreams {
+					ch.putmbuf(p)
+					return mh, nil, errTooManyFragmented
+				}
+				f = &fragment{}
+				ch.fragments[mh.StreamID] = f
+			}
+			f.size += len(p)
+			// The messages being reassembled are bounded together by the
+			// maximum size, so that a peer interleaving many large messages
+			// does not hold more than a single one.
+			if !f.discard && ch.pending+len(p) > ch.maxRecvMsgSize {
+				f.discard = true
+				ch.pending -= len(f.buf)
+				f.buf = nil
+			}
+			if !f.discard {
+				f.buf = append(f.buf, p...)
+				ch.pending += len(p)
+			}
+			ch.putmbuf(p)
+			continue
+		}
+		if !ok {
+			if len(p) > ch.maxRecvMsgSize {
+				ch.putmbuf(p)
+				return mh, nil, ch.errRecvTooLarge(len(p))
+			}
+			return mh, p, nil
+		}
+		delete(ch.fragments, mh.StreamID)
+		ch.pending -= len(f.buf)
+		if n := f.size + len(p); n > ch.maxRecvMsgSize {
+			ch.putmbuf(p)
+			return mh, nil, ch.errRecvTooLarge(n)
+		}
+		if f.discard {
+			ch.putmbuf(p)
+			return mh, nil, status.Errorf(codes.ResourceExhausted, "received messages larger than max (%d) together", ch.maxRecvMsgSize)
+		}
+		buf := append(f.buf, p...)
+		ch.putmbuf(p)
+		mh.Length = uint32(len(buf))
+		return mh, buf, nil
+	}
+}
+
+// errRecvTooLarge returns the error for a received message exceeding the maximum size.
+func (ch *channel) errRecvTooLarge(n int) error {
+	return status.Errorf(codes.ResourceExhausted, "received message larger than max (%d vs. %d)", n, ch.maxRecvMsgSize)
+}
+
+// recv receives a single frame from the channel.
+func (ch *channel) recv() (messageHeader, []byte, error) {
 	var hrbuf [messageHeaderLength]byte // avoid alloc when reading header
 	_, err := io.ReadFull(ch.br, hrbuf[:])
 	if err != nil {
-		return 0, nil, err
+		return messageHeader{}, nil, err
 	}
 
-	h := binary.BigEndian.Uint32(hrbuf[:4])
-	s := binary.BigEndian.Uint32(hrbuf[4:])
+	mh := messageHeader{
+		Length:   binary.BigEndian.Uint32(hrbuf[:4]),
+		StreamID: binary.BigEndian.Uint32(hrbuf[4:8]),
+		Type:     messageType(hrbuf[8]),
+		Flags:    hrbuf[9],
+	}
 
-	if h > uint32(messageLengthMax) {
-		if _, err := ch.br.Discard(int(h)); err != nil {
-			return 0, nil, err
+	if mh.Length > uint32(messageLengthMax) {
+		if _, err := ch.br.Discard(int(mh.Length)); err != nil {
+			return mh, nil, err
 		}
 
-		return 0, nil, status.OutOfRange.Err()
+		return mh, nil, status.OutOfRange.Err()
 	}
 
 	var p []byte
-	if h > 0 {
-		p = ch.getmbuf(int(h))
+	if mh.Length > 0 {
+		p = ch.getmbuf(int(mh.Length))
 		if _, err := io.ReadFull(ch.br, p); err != nil {
-			return 0, nil, err
+			return mh, nil, err
 		}
 	}
 
-	return s, p, nil
+	return mh, p, nil
+}
+
+// Send sends a message to the channel. The message is prefixed with a fixed-length header containing the length of the message, the stream ID, the message type and its flags.
+// Messages larger than a single frame are split into fragments, fragments of
+// different streams may be interleaved.
+//
+// Send is safe for concurrent use by multiple goroutines.
+func (ch *channel) Send(streamID uint32, t messageType, flags uint8, p []byte) error {
+	if len(p) > ch.maxSendMsgSize {
+		return status.Errorf(codes.ResourceExhausted, "trying to send message larger than max (%d vs. %d)", len(p), ch.maxSendMsgSize)
+	}
+	for len(p) > messageLengthMax {
+		if err := ch.send(streamID, t, flags|flagFragment, p[:messageLengthMax]); err != nil {
+			return err
+		}
+		p = p[messageLengthMax:]
+	}
+	return ch.send(streamID, t, flags, p)
 }
 
-// Send sends a message to the channel. The message is prefixed with a fixed-length header containing the length of the message and the stream ID.
-func (ch *channel) Send(streamID uint32, p []byte) error {
+// send sends a single frame to the channel.
+func (ch *channel) send(streamID uint32, t messageType, flags uint8, p []byte) error {
 	if len(p) > messageLengthMax {
 		return status.DataLoss.Err()
 	}
 	hwbuf := ch.getmbuf(messageHeaderLength + len(p))
 	defer ch.putmbuf(hwbuf)
 	binary.BigEndian.PutUint32(hwbuf[:4], uint32(len(p)))
-	binary.BigEndian.PutUint32(hwbuf[4:], streamID)
+	binary.BigEndian.PutUint32(hwbuf[4:8], streamID)
+	hwbuf[8] = byte(t)
+	hwbuf[9] = flags
 	copy(hwbuf[messageHeaderLength:], p)
 
+	ch.wmu.Lock()
+	defer ch.wmu.Unlock()
 	_, err := ch.Write(hwbuf)
 	if err != nil {
 		return err
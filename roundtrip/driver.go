@@ -7,6 +7,7 @@ import (
 	"github.com/vimcoders/grpcx/generated/api"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 )
 
 // RoundTripper is an interface representing the ability to execute a
@@ -41,5 +42,10 @@ type RoundTripper interface {
 	// The Request's URL and Header fields must be initialized.
 	grpc.ClientConnInterface
 	RoundTrip(ctx context.Context, req *api.Request) (*api.Response, error)
+	// GetState returns the connectivity state of the connection.
+	GetState() connectivity.State
+	// WaitForStateChange waits until the state changes from sourceState or
+	// ctx is done. It returns true in the former case.
+	WaitForStateChange(ctx context.Context, sourceState connectivity.State) bool
 	io.Closer
 }
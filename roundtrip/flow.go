@@ -0,0 +1,136 @@
+package roundtrip
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// defaultStreamWindow is the number of bytes of data messages a peer may send
+// on a stream before the reader of the stream takes them.
+const defaultStreamWindow = 1 << 20
+
+// messageQueue is an unbounded queue of the messages received on a stream.
+// The goroutine reading the connection puts messages without ever blocking,
+// so a stream whose reader is slow does not hold up the others. The send
+// window of the peer bounds what is queued.
+type messageQueue[T any] struct {
+	mu     sync.Mutex
+	items  []T
+	closed bool
+	// ready holds a token whenever the queue may have changed.
+	ready chan struct{}
+}
+
+func newMessageQueue[T any]() *messageQueue[T] {
+	return &messageQueue[T]{ready: make(chan struct{}, 1)}
+}
+
+// put appends v to the queue.
+func (q *messageQueue[T]) put(v T) {
+	q.mu.Lock()
+	q.items = append(q.items, v)
+	q.mu.Unlock()
+	q.notify()
+}
+
+// close marks the end of the queue, nothing is put after.
+func (q *messageQueue[T]) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.notify()
+}
+
+func (q *messageQueue[T]) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop takes the first message of the queue. It reports false if the queue is
+// empty, closed tells whether more messages may come then.
+func (q *messageQueue[T]) pop() (v T, ok, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return v, false, q.closed
+	}
+	v = q.items[0]
+	var zero T
+	q.items[0] = zero
+	q.items = q.items[1:]
+	if len(q.items) > 0 {
+		q.notify()
+	}
+	return v, true, false
+}
+
+// sendWindow is the credit of a stream for sending data messages, in bytes.
+// A message is sent while the credit is positive, so that a message larger
+// than the window is still sent once the peer took the previous ones.
+type sendWindow struct {
+	mu     sync.Mutex
+	credit int64
+	// changed is closed and replaced whenever the credit grows.
+	changed chan struct{}
+}
+
+func newSendWindow() *sendWindow {
+	return &sendWindow{credit: defaultStreamWindow, changed: make(chan struct{})}
+}
+
+// acquire takes n bytes from the credit once it is positive. It reports false
+// if closed or done is closed before.
+func (w *sendWindow) acquire(n int, closed, done <-chan struct{}) bool {
+	for {
+		w.mu.Lock()
+		if w.credit > 0 {
+			w.credit -= int64(n)
+			w.mu.Unlock()
+			return true
+		}
+		changed := w.changed
+		w.mu.Unlock()
+		select {
+		case <-changed:
+		case <-closed:
+			return false
+		case <-done:
+			return false
+		}
+	}
+}
+
+// add grows the credit by the window update received from the peer.
+func (w *sendWindow) add(p []byte) {
+	if len(p) < 4 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.credit += int64(binary.BigEndian.Uint32(p))
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// recvWindow counts the bytes of data messages taken by the reader of a
+// stream, they are granted back to the peer by quarters of the window.
+type recvWindow struct {
+	mu       sync.Mutex
+	consumed uint32
+}
+
+// take records n bytes taken by the reader. It returns the window update to
+// send to the peer, nil if it is not due yet.
+func (w *recvWindow) take(n int) []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.consumed += uint32(n)
+	if w.consumed < defaultStreamWindow/4 {
+		return nil
+	}
+	p := binary.BigEndian.AppendUint32(nil, w.consumed)
+	w.consumed = 0
+	return p
+}
@@ -0,0 +1,275 @@
+package roundtrip
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// defaultGrowBackoff is the time a pool waits before dialing another
+// connection once a dial failed.
+const defaultGrowBackoff = time.Second
+
+// pooledConn is a connection of a pool.
+type pooledConn struct {
+	*roundtrip
+	// pending is the number of calls picking the connection whose stream is
+	// not created yet, guarded by the pool.
+	pending int
+}
+
+// pool is a RoundTripper over several connections to the same target. Calls
+// go to the Ready connection with the most free streams. Once the streams of
+// all the connections are in use, another connection is dialed in the
+// background up to maxConns, and calls wait for a stream if waitForStream is
+// set. Additional connections with no stream for idleTimeout are closed, the
+// first one is kept.
+type pool struct {
+	mu            sync.Mutex
+	conns         []*pooledConn
+	dial          func(ctx context.Context) (*roundtrip, error)
+	maxConns      int
+	idleTimeout   time.Duration
+	waitForStream bool
+	timeout       time.Duration
+	// dialing is set while a connection is dialed, growAfter is the time
+	// before which no connection is dialed once a dial failed.
+	dialing   bool
+	growAfter time.Time
+	// changed is closed and replaced whenever a stream is released or a
+	// connection is added.
+	changed chan struct{}
+	closed  bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// newPool creates a pool whose first connection is rt, dial dials the others.
+func newPool(rt *roundtrip, dial func(ctx context.Context) (*roundtrip, error)) *pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pool{
+		conns:         []*pooledConn{{roundtrip: rt}},
+		dial:          dial,
+		maxConns:      max(1, rt.maxConns),
+		idleTimeout:   rt.idleTimeout,
+		waitForStream: rt.waitForStream,
+		timeout:       rt.timeout,
+		changed:       make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	rt.setReleased(p.release)
+	if p.maxConns > 1 && p.idleTimeout > 0 {
+		go p.run(ctx)
+	}
+	return p
+}
+
+// release wakes up the calls waiting for a stream.
+func (p *pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.broadcastLocked()
+}
+
+func (p *pool) broadcastLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// pick returns the connection to create a stream on, done must be called
+// once the stream is created or failed to be. If no connection is Ready, the
+// first one is returned, it waits for its connection or fails the call.
+func (p *pool) pick(ctx context.Context) (conn *pooledConn, done func(), err error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, nil, status.Canceled.Err()
+		}
+		var free int
+		var ready bool
+		for _, c := range p.conns {
+			n, ok := c.freeStreams()
+			if !ok {
+				continue
+			}
+			ready = true
+			if n -= c.pending; n > free {
+				conn, free = c, n
+			}
+		}
+		if conn == nil && !ready {
+			conn = p.conns[0]
+		}
+		if conn != nil {
+			conn.pending++
+			p.mu.Unlock()
+			return conn, func() {
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				conn.pending--
+			}, nil
+		}
+		if !p.dialing && len(p.conns) < p.maxConns && !time.Now().Before(p.growAfter) {
+			p.dialing = true
+			go p.grow()
+		}
+		// Calls wait for the connection being dialed, or for a stream if
+		// waitForStream is set.
+		changed := p.changed
+		wait := p.waitForStream || p.dialing
+		p.mu.Unlock()
+		if !wait {
+			return nil, nil, errNoStream
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// grow dials another connection and adds it to the pool.
+func (p *pool) grow() {
+	rt, err := p.dial(p.ctx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing = false
+	defer p.broadcastLocked()
+	if err != nil {
+		p.growAfter = time.Now().Add(defaultGrowBackoff)
+		return
+	}
+	if p.closed {
+		_ = rt.Close()
+		return
+	}
+	rt.setReleased(p.release)
+	p.conns = append(p.conns, &pooledConn{roundtrip: rt})
+}
+
+// run closes the idle additional connections until ctx is done.
+func (p *pool) run(ctx context.Context) {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.closeIdle(now.Add(-p.idleTimeout))
+		}
+	}
+}
+
+// closeIdle closes the additional connections with no stream since before
+// deadline.
+func (p *pool) closeIdle(deadline time.Time) {
+	var idle []*pooledConn
+	p.mu.Lock()
+	p.conns = slices.DeleteFunc(p.conns, func(c *pooledConn) bool {
+		if c == p.conns[0] || c.pending > 0 || !c.idle(deadline) {
+			return false
+		}
+		idle = append(idle, c)
+		return true
+	})
+	p.mu.Unlock()
+	for _, c := range idle {
+		_ = c.Close()
+	}
+}
+
+// Invoke invokes the method on a connection with a free stream, see
+// roundtrip.Invoke.
+func (p *pool) Invoke(ctx context.Context, method string, req any, reply any, opts ...grpc.CallOption) error {
+	ctx, cancel := withCallTimeout(ctx, p.timeout, opts)
+	defer cancel()
+	for {
+		conn, done, err := p.pick(ctx)
+		if err != nil {
+			return err
+		}
+		err = conn.Invoke(ctx, method, req, reply, opts...)
+		done()
+		if !errors.Is(err, errNoStream) {
+			return err
+		}
+	}
+}
+
+// NewStream creates a stream on a connection with a free stream, see
+// roundtrip.NewStream.
+func (p *pool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	for {
+		conn, done, err := p.pick(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s, err := conn.NewStream(ctx, desc, method, opts...)
+		done()
+		if !errors.Is(err, errNoStream) {
+			return s, err
+		}
+	}
+}
+
+// RoundTrip sends the request on a connection with a free stream, see
+// roundtrip.RoundTrip.
+func (p *pool) RoundTrip(ctx context.Context, req *api.Request) (*api.Response, error) {
+	ctx, cancel := withCallTimeout(ctx, p.timeout, nil)
+	defer cancel()
+	for {
+		conn, done, err := p.pick(ctx)
+		if err != nil {
+			return nil, err
+		}
+		response, err := conn.RoundTrip(ctx, req)
+		done()
+		if !errors.Is(err, errNoStream) {
+			return response, err
+		}
+	}
+}
+
+// GetState returns the connectivity state of the first connection.
+func (p *pool) GetState() connectivity.State {
+	return p.first().GetState()
+}
+
+// WaitForStateChange waits until the state of the first connection changes
+// from sourceState or ctx is done. It returns true in the former case.
+func (p *pool) WaitForStateChange(ctx context.Context, sourceState connectivity.State) bool {
+	return p.first().WaitForStateChange(ctx, sourceState)
+}
+
+func (p *pool) first() *roundtrip {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conns[0].roundtrip
+}
+
+// Close closes all the connections of the pool.
+func (p *pool) Close() error {
+	p.cancel()
+	p.mu.Lock()
+	p.closed = true
+	conns := p.conns
+	p.broadcastLocked()
+	p.mu.Unlock()
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	return nil
+}
@@ -18,7 +18,9 @@ package roundtrip
 import (
 	"context"
 	"math"
+	"math/rand"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,8 +32,11 @@ import (
 
 	"github.com/vimcoders/grpcx/encoding"
 
+	spb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 )
 
 const (
@@ -39,8 +44,16 @@ const (
 	defaultMaxStreams = 64
 	// DefaultTimeout is the default timeout for each request.
 	defaultTimeout = 3 * time.Second
+	// defaultMinConnectTimeout is the default time given to every dial attempt.
+	defaultMinConnectTimeout = 20 * time.Second
+	// defaultIdleTimeout is the default time after which an additional
+	// connection of a pool with no stream is closed.
+	defaultIdleTimeout = time.Minute
 )
 
+// errNoStream is returned when all the streams of the transport are in use.
+var errNoStream = status.Error(codes.ResourceExhausted, "roundtrip: all streams in use")
+
 // RoundTripper is the interface for sending and receiving messages over a transport.
 type Option func(*roundtrip)
 
@@ -51,13 +64,53 @@ func WithMaxStreams(n int) Option {
 	}
 }
 
-// WithCodec sets the codec for the ttrpc transport.
+// WithMaxConns sets the maximum number of connections to the target. Once
+// the streams of all the connections are in use, another connection is
+// dialed, up to n. It is 1 by default.
+func WithMaxConns(n int) Option {
+	return func(t *roundtrip) {
+		t.maxConns = n
+	}
+}
+
+// WithIdleTimeout sets the time after which an additional connection with no
+// stream is closed, see WithMaxConns. Zero means they are never closed.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(t *roundtrip) {
+		t.idleTimeout = d
+	}
+}
+
+// WithWaitForStream makes calls wait for a stream until their deadline once
+// the streams of all the connections are in use, instead of failing with
+// ResourceExhausted at once.
+func WithWaitForStream(wait bool) Option {
+	return func(t *roundtrip) {
+		t.waitForStream = wait
+	}
+}
+
+// WithTimeout sets the timeout for calls whose context has no deadline. Zero means such calls have no deadline.
 func WithTimeout(d time.Duration) Option {
 	return func(t *roundtrip) {
 		t.timeout = d
 	}
 }
 
+// WithMaxRecvMsgSize sets the maximum size of a message the transport can receive.
+func WithMaxRecvMsgSize(n int) Option {
+	return func(t *roundtrip) {
+		t.maxRecvMsgSize = n
+	}
+}
+
+// WithMaxSendMsgSize sets the maximum size of a message the transport can send.
+func WithMaxSendMsgSize(n int) Option {
+	return func(t *roundtrip) {
+		t.maxSendMsgSize = n
+	}
+}
+
 // WithCodec sets the codec for the ttrpc transport.
 func WithCodec(c encoding.Codec) Option {
 	return func(t *roundtrip) {
@@ -65,6 +118,28 @@ func WithCodec(c encoding.Codec) Option {
 	}
 }
 
+// TimeoutCallOption is a grpc.CallOption bounding the duration of a single
+// call. Use CallTimeout to create one.
+type TimeoutCallOption struct {
+	grpc.EmptyCallOption
+	Timeout time.Duration
+}
+
+// CallTimeout returns a grpc.CallOption setting the timeout of a single call,
+// it overrides the timeout of the transport. Zero means the call is only
+// bounded by the deadline of its context.
+func CallTimeout(d time.Duration) grpc.CallOption {
+	return TimeoutCallOption{Timeout: d}
+}
+
+// WithConnectParams sets the backoff between redials of a broken connection
+// and the time given to every dial attempt.
+func WithConnectParams(p grpc.ConnectParams) Option {
+	return func(t *roundtrip) {
+		t.connectParams = p
+	}
+}
+
 // Transport is the implementation of RoundTripper for ttrpc.
 type roundtrip struct {
 	RoundTripper
@@ -79,6 +154,24 @@ type roundtrip struct {
 	closed      func()
 	dialContext func(ctx context.Context) (net.Conn, error)
 	timeout     time.Duration
+
+	maxConns      int
+	idleTimeout   time.Duration
+	waitForStream bool
+	// released, if set, is called whenever streams are deleted.
+	released func()
+	// idleSince is the time the last stream was deleted.
+	idleSince time.Time
+
+	maxRecvMsgSize int
+	maxSendMsgSize int
+
+	connectParams grpc.ConnectParams
+	state         connectivity.State
+	// stateChange is closed and replaced whenever the state changes.
+	stateChange chan struct{}
+	// lastErr is the error the last connection attempt failed with.
+	lastErr error
 }
 
 // Dial creates a new ttrpc transport to the given target.
@@ -87,70 +180,333 @@ func Dial(target string, opts ...Option) (RoundTripper, error) {
 }
 
 // DialContext creates a new ttrpc transport to the given target with the given context.
+// The target is a TCP address, or a unix socket written as unix:path or unix:///path.
+//
+// The first connection is established before DialContext returns. Once it
+// breaks, the transport redials in the background with exponential backoff
+// until it is closed. With WithMaxConns or WithWaitForStream, the transport
+// is a pool of connections to the target.
 func DialContext(ctx context.Context, target string, opts ...Option) (RoundTripper, error) {
+	rt, err := dial(ctx, target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if rt.maxConns <= 1 && !rt.waitForStream {
+		return rt, nil
+	}
+	return newPool(rt, func(ctx context.Context) (*roundtrip, error) {
+		return dial(ctx, target, opts...)
+	}), nil
+}
+
+// dial creates a transport of a single connection to the target.
+func dial(ctx context.Context, target string, opts ...Option) (*roundtrip, error) {
+	network, address := parseDialTarget(target)
 	dialContext := func(ctx context.Context) (net.Conn, error) {
 		d := net.Dialer{
 			KeepAlive: time.Minute,
 		}
-		cc, err := d.DialContext(ctx, "tcp", target)
+		cc, err := d.DialContext(ctx, network, address)
 		if err != nil {
 			return nil, err
 		}
 		return cc, nil
 	}
-	cc, err := dialContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithCancel(context.Background())
+	closeCtx, cancel := context.WithCancel(context.Background())
 	rt := &roundtrip{
-		channel:     newChannel(cc),
-		c:           cc,
-		maxStreams:  defaultMaxStreams,
-		ctx:         ctx,
-		closed:      cancel,
-		streams:     make(map[uint32]*stream),
-		Codec:       encoding.GetCodec(encoding.Name),
-		dialContext: dialContext,
-		timeout:     defaultTimeout,
+		maxStreams:     defaultMaxStreams,
+		ctx:            closeCtx,
+		closed:         cancel,
+		streams:        make(map[uint32]*stream),
+		Codec:          encoding.GetCodec(encoding.Name),
+		dialContext:    dialContext,
+		timeout:        defaultTimeout,
+		maxRecvMsgSize: defaultMaxRecvMsgSize,
+		maxSendMsgSize: defaultMaxSendMsgSize,
+		maxConns:       1,
+		idleTimeout:    defaultIdleTimeout,
+		idleSince:      time.Now(),
+		connectParams: grpc.ConnectParams{
+			Backoff:           backoff.DefaultConfig,
+			MinConnectTimeout: defaultMinConnectTimeout,
+		},
+		state:       connectivity.Idle,
+		stateChange: make(chan struct{}),
 	}
 	for _, o := range opts {
 		o(rt)
 	}
+	rt.setState(connectivity.Connecting, nil)
+	cc, err := rt.connect(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	rt.setConn(cc)
 	go func() {
-		_ = rt.run(ctx)
+		_ = rt.run(closeCtx)
 	}()
 	return rt, nil
 }
 
-// run runs the receive loop for the transport. It receives messages from the channel and dispatches them to the appropriate stream. If the context is canceled, it closes the transport and returns an error.
+// parseDialTarget returns the network and address to dial for the target.
+func parseDialTarget(target string) (string, string) {
+	if path, ok := strings.CutPrefix(target, "unix:"); ok {
+		// unix:///path has an empty authority.
+		if rest, ok := strings.CutPrefix(path, "//"); ok {
+			path = rest
+		}
+		return "unix", path
+	}
+	return "tcp", target
+}
+
+// GetState returns the connectivity state of the transport.
+func (t *roundtrip) GetState() connectivity.State {
+	t.RLock()
+	defer t.RUnlock()
+	return t.state
+}
+
+// WaitForStateChange waits until the state of the transport changes from
+// sourceState or ctx is done. It returns true in the former case.
+func (t *roundtrip) WaitForStateChange(ctx context.Context, sourceState connectivity.State) bool {
+	t.RLock()
+	state, changed := t.state, t.stateChange
+	t.RUnlock()
+	if state != sourceState {
+		return true
+	}
+	select {
+	case <-changed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// setState moves the transport to the given state, err is the reason of a
+// transient failure. A transport which is shut down stays so.
+func (t *roundtrip) setState(state connectivity.State, err error) {
+	t.Lock()
+	defer t.Unlock()
+	t.setStateLocked(state, err)
+}
+
+func (t *roundtrip) setStateLocked(state connectivity.State, err error) {
+	if t.state == connectivity.Shutdown || t.state == state {
+		return
+	}
+	t.state = state
+	if err != nil {
+		t.lastErr = err
+	}
+	close(t.stateChange)
+	t.stateChange = make(chan struct{})
+}
+
+// connect dials a new connection, the attempt is bounded by the minimum
+// connect timeout.
+func (t *roundtrip) connect(ctx context.Context) (net.Conn, error) {
+	if t.connectParams.MinConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.connectParams.MinConnectTimeout)
+		defer cancel()
+	}
+	return t.dialContext(ctx)
+}
+
+// setConn makes cc the connection of the transport and moves it to Ready.
+func (t *roundtrip) setConn(cc net.Conn) {
+	t.Lock()
+	defer t.Unlock()
+	if t.state == connectivity.Shutdown {
+		_ = cc.Close()
+		return
+	}
+	t.c = cc
+	t.channel = newChannel(cc, t.maxRecvMsgSize, t.maxSendMsgSize)
+	t.setStateLocked(connectivity.Ready, nil)
+}
+
+// resetConn closes the broken connection of the transport, its streams fail
+// with Unavailable.
+func (t *roundtrip) resetConn(err error) {
+	t.Lock()
+	if t.c != nil {
+		_ = t.c.Close()
+	}
+	t.setStateLocked(connectivity.Connecting, err)
+	for sid, s := range t.streams {
+		delete(t.streams, sid)
+		s.close()
+	}
+	t.idleSince = time.Now()
+	released := t.released
+	t.Unlock()
+	if released != nil {
+		released()
+	}
+}
+
+// run serves the connection of the transport until ctx is done. Whenever the
+// connection breaks, it is redialed with exponential backoff.
 func (t *roundtrip) run(ctx context.Context) error {
 	defer t.Close()
+	for {
+		t.RLock()
+		ch := t.channel
+		t.RUnlock()
+		err := t.serve(ctx, ch)
+		if ctx.Err() != nil {
+			return status.Canceled.Err()
+		}
+		t.resetConn(err)
+		if err := t.reconnect(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// reconnect redials the transport until a connection is established or ctx is
+// done. Failed attempts move the transport to TransientFailure until the next
+// one.
+func (t *roundtrip) reconnect(ctx context.Context) error {
+	for retries := 0; ; retries++ {
+		t.setState(connectivity.Connecting, nil)
+		cc, err := t.connect(ctx)
+		if err == nil {
+			t.setConn(cc)
+			return nil
+		}
+		t.setState(connectivity.TransientFailure, err)
+		timer := time.NewTimer(t.backoff(retries))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return status.Canceled.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the time to wait before the next dial after the given
+// number of failed retries, as specified by the gRPC connection backoff.
+func (t *roundtrip) backoff(retries int) time.Duration {
+	cfg := t.connectParams.Backoff
+	if retries == 0 {
+		return cfg.BaseDelay
+	}
+	backoff, max := float64(cfg.BaseDelay), float64(cfg.MaxDelay)
+	for backoff < max && retries > 0 {
+		backoff *= cfg.Multiplier
+		retries--
+	}
+	if backoff > max {
+		backoff = max
+	}
+	// Randomize the delay, so that clients which lost their connection
+	// together do not redial together.
+	backoff *= 1 + cfg.Jitter*(rand.Float64()*2-1)
+	if backoff < 0 {
+		return 0
+	}
+	return time.Duration(backoff)
+}
+
+// waitForReady blocks until the transport is Ready. A transport in
+// TransientFailure fails the call with Unavailable at once, unless the call
+// asked to wait for ready with grpc.WaitForReady.
+func (t *roundtrip) waitForReady(ctx context.Context, opts []grpc.CallOption) error {
+	var waitForReady bool
+	for _, o := range opts {
+		if o, ok := o.(grpc.FailFastCallOption); ok {
+			waitForReady = !o.FailFast
+		}
+	}
+	for {
+		t.RLock()
+		state, changed, lastErr := t.state, t.stateChange, t.lastErr
+		t.RUnlock()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Shutdown:
+			return status.Canceled.Err()
+		case connectivity.TransientFailure:
+			if !waitForReady {
+				return status.Errorf(codes.Unavailable, "connection error: %v", lastErr)
+			}
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// serve runs the receive loop for a connection of the transport. It receives
+// messages from the channel and dispatches them to the appropriate stream
+// until the connection fails or the context is canceled.
+func (t *roundtrip) serve(ctx context.Context, ch *channel) error {
 	codec := encoding.GetCodec(encoding.Name)
 	for {
 		select {
 		case <-ctx.Done():
 			return status.Canceled.Err()
 		default:
-			streamID, payload, err := t.channel.Recv()
+			mh, payload, err := ch.Recv()
 			if err != nil {
-				return err
+				if _, ok := status.FromError(err); !ok {
+					return err
+				}
+				// The message was discarded, fail the stream it belongs to.
+				if s := t.getStream(mh.StreamID); s != nil {
+					s.closeWithError(err)
+				}
+				continue
 			}
-			s := t.getStream(streamID)
+			s := t.getStream(mh.StreamID)
 			if s == nil {
-				t.channel.putmbuf(payload)
+				ch.putmbuf(payload)
 				continue
 			}
-			var response api.Response
-			if err := codec.Unmarshal(payload, &response); err != nil {
-				s.close()
-				t.channel.putmbuf(payload)
+			msg := &streamMessage{header: mh}
+			switch mh.Type {
+			case messageTypeResponse:
+				var response api.Response
+				if err := codec.Unmarshal(payload, &response); err != nil {
+					s.close()
+					ch.putmbuf(payload)
+					continue
+				}
+				ch.putmbuf(payload)
+				msg.response = &response
+				// The header of a call arrives with its response unless it was sent before.
+				s.setHeader(PairsMetadata(response.Headers))
+			case messageTypeHeader:
+				var response api.Response
+				err := codec.Unmarshal(payload, &response)
+				ch.putmbuf(payload)
+				if err != nil {
+					s.close()
+					continue
+				}
+				s.setHeader(PairsMetadata(response.Headers))
 				continue
-			}
-			t.channel.putmbuf(payload)
-			if err := s.receive(ctx, &response); err != nil {
+			case messageTypeData:
+				msg.payload = payload
+			case messageTypeWindowUpdate:
+				s.sendWindow.add(payload)
+				ch.putmbuf(payload)
+				continue
+			default:
+				ch.putmbuf(payload)
 				continue
 			}
+			// Messages are queued, a slow stream does not block the others.
+			_ = s.receive(msg)
 		}
 	}
 }
@@ -162,17 +518,21 @@ func (t *roundtrip) createStream(ctx context.Context) (*stream, error) {
 
 	select {
 	case <-ctx.Done():
-		return nil, status.Canceled.Err()
+		return nil, status.FromContextError(ctx.Err()).Err()
 	default:
+		// The connection broke after the caller waited for it.
+		if t.state != connectivity.Ready {
+			return nil, status.Unavailable.Err()
+		}
 		if t.maxStreams > 0 && len(t.streams) >= t.maxStreams {
-			return nil, status.ResourceExhausted.Err()
+			return nil, errNoStream
 		}
 		for i := uint32(1); i < math.MaxInt8; i++ {
 			streamID := t.streamID + i
 			if _, ok := t.streams[streamID]; ok {
 				continue
 			}
-			s := newStream(streamID, t.channel)
+			s := newStream(ctx, streamID, t.channel)
 			t.streams[s.id] = s
 			t.streamID = streamID
 			return s, nil
@@ -182,16 +542,105 @@ func (t *roundtrip) createStream(ctx context.Context) (*stream, error) {
 }
 
 // NewStream creates a new stream with the given context. It returns an error if the maximum number of streams has been reached or if the context is canceled.
+//
+// The stream stays open until the server finishes it, the context is done or
+// the transport is closed.
 func (t *roundtrip) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-	return t.createStream(ctx)
+	// Streams are long lived, the timeout of the transport does not apply.
+	ctx, cancel := withCallTimeout(ctx, 0, opts)
+	timeout, err := encodeTimeout(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	request := &api.Request{
+		Method:  method,
+		Timeout: timeout,
+	}
+	if medatas, ok := metadata.GetMetadata(ctx); ok {
+		for k, v := range medatas {
+			request.Metadatas = append(request.Metadatas, k, v)
+		}
+	}
+	codec := encoding.GetCodec(encoding.Name)
+	b, err := codec.Marshal(request)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := t.waitForReady(ctx, opts); err != nil {
+		cancel()
+		return nil, err
+	}
+	s, err := t.createStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.desc = desc
+	s.opts = opts
+	s.Codec = t.Codec
+	if err := s.send(messageTypeRequest, flagRemoteOpen, b); err != nil {
+		cancel()
+		t.deleteStream(s)
+		return nil, err
+	}
+	go func() {
+		defer cancel()
+		select {
+		case <-ctx.Done():
+			s.closeWithError(status.FromContextError(ctx.Err()).Err())
+			// Let the server stop the handler, nobody reads its response.
+			_ = s.send(messageTypeCancel, flagNoData, nil)
+		case <-t.ctx.Done():
+		case <-s.recvClose:
+		}
+		t.deleteStream(s)
+	}()
+	return s, nil
 }
 
 // deleteStream deletes the given stream from the transport. It closes the stream and removes it from the map of streams.
 func (t *roundtrip) deleteStream(s *stream) {
 	t.Lock()
-	defer t.Unlock()
 	delete(t.streams, s.id)
 	s.close()
+	if len(t.streams) == 0 {
+		t.idleSince = time.Now()
+	}
+	released := t.released
+	t.Unlock()
+	if released != nil {
+		released()
+	}
+}
+
+// freeStreams returns the number of streams which can still be created, it
+// reports false if the transport is not Ready.
+func (t *roundtrip) freeStreams() (int, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	if t.state != connectivity.Ready {
+		return 0, false
+	}
+	if t.maxStreams <= 0 {
+		return math.MaxInt, true
+	}
+	return t.maxStreams - len(t.streams), true
+}
+
+// idle reports whether the transport has had no stream since before deadline.
+func (t *roundtrip) idle(deadline time.Time) bool {
+	t.RLock()
+	defer t.RUnlock()
+	return len(t.streams) == 0 && t.idleSince.Before(deadline)
+}
+
+// setReleased sets the function called whenever streams are deleted.
+func (t *roundtrip) setReleased(released func()) {
+	t.Lock()
+	defer t.Unlock()
+	t.released = released
 }
 
 // getStream returns the stream with the given stream ID. It returns nil if the stream does not exist.
@@ -213,7 +662,20 @@ func (t *roundtrip) cleanupStreams() {
 }
 
 // Invoke invokes the given method with the given request and response. It marshals the request, sends it to the server, and unmarshals the response. If the context is canceled, it returns an error.
+//
+// The call is bounded by the deadline of ctx. Without one, the timeout set by
+// a CallTimeout option or else the timeout of the transport applies. The
+// remaining time is sent along, so the server stops at the same deadline.
+//
+// While the transport reconnects, the call waits for the connection unless the
+// last attempt failed. grpc.WaitForReady makes it wait in that case as well.
 func (t *roundtrip) Invoke(ctx context.Context, method string, req any, reply any, opts ...grpc.CallOption) error {
+	ctx, cancel := withCallTimeout(ctx, t.timeout, opts)
+	defer cancel()
+	timeout, err := encodeTimeout(ctx)
+	if err != nil {
+		return err
+	}
 	payload, err := t.Marshal(req)
 	if err != nil {
 		return err
@@ -221,20 +683,20 @@ func (t *roundtrip) Invoke(ctx context.Context, method string, req any, reply an
 	request := &api.Request{
 		Method:  method,
 		Payload: payload,
-		Timeout: t.timeout.Milliseconds(),
+		Timeout: timeout,
 	}
 	if medatas, ok := metadata.GetMetadata(ctx); ok {
 		for k, v := range medatas {
 			request.Metadatas = append(request.Metadatas, k, v)
 		}
 	}
-	response, err := t.RoundTrip(ctx, request)
+	response, err := t.roundTrip(ctx, request, opts)
 	if err != nil {
 		return err
 	}
-	code := codes.Code(response.Code)
-	if code != codes.OK {
-		return status.Error(code, response.Message)
+	setCallMetadata(opts, PairsMetadata(response.Headers), PairsMetadata(response.Trailers))
+	if err := responseError(response); err != nil {
+		return err
 	}
 	if err = t.Unmarshal(response.Payload, reply); err != nil {
 		return err
@@ -242,33 +704,100 @@ func (t *roundtrip) Invoke(ctx context.Context, method string, req any, reply an
 	return nil
 }
 
+// withCallTimeout returns the context bounding a call. A CallTimeout option
+// takes precedence, otherwise the default timeout applies unless ctx already
+// has a deadline. A zero timeout leaves ctx unbounded.
+func withCallTimeout(ctx context.Context, timeout time.Duration, opts []grpc.CallOption) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		timeout = 0
+	}
+	for _, o := range opts {
+		if o, ok := o.(TimeoutCallOption); ok {
+			timeout = o.Timeout
+		}
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// encodeTimeout returns the time remaining until the deadline of ctx in
+// milliseconds as sent on the wire, rounded up so that a pending deadline is
+// never sent as zero. Zero means the call has no deadline. A deadline which
+// has passed already fails with DeadlineExceeded.
+func encodeTimeout(ctx context.Context) (int64, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, nil
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return 0, status.DeadlineExceeded.Err()
+	}
+	return int64((timeout + time.Millisecond - 1) / time.Millisecond), nil
+}
+
+// responseError rebuilds the status carried by the response. It returns nil if the call succeeded.
+func responseError(response *api.Response) error {
+	if codes.Code(response.Code) == codes.OK {
+		return nil
+	}
+	return status.FromProto(&spb.Status{
+		Code:    response.Code,
+		Message: response.Message,
+		Details: response.Details,
+	}).Err()
+}
+
 // RoundTrip sends the given request to the server and returns the response. It creates a new stream, sends the request, and waits for the response. If the context is canceled, it returns an error.
+//
+// The timeout of the transport applies if ctx has no deadline, the timeout
+// sent to the server is the one set on req. If the call is abandoned because
+// ctx is done, the server is told to cancel the handler.
 func (t *roundtrip) RoundTrip(ctx context.Context, req *api.Request) (*api.Response, error) {
-	codec := encoding.GetCodec(encoding.Name)
-	timeoutCtx, cancel := context.WithTimeout(ctx, t.timeout)
+	ctx, cancel := withCallTimeout(ctx, t.timeout, nil)
 	defer cancel()
+	return t.roundTrip(ctx, req, nil)
+}
+
+// roundTrip sends the request on a new stream and waits for the response until
+// ctx is done.
+func (t *roundtrip) roundTrip(ctx context.Context, req *api.Request, opts []grpc.CallOption) (*api.Response, error) {
+	codec := encoding.GetCodec(encoding.Name)
 	b, err := codec.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
-	s, err := t.createStream(timeoutCtx)
+	if err := t.waitForReady(ctx, opts); err != nil {
+		return nil, err
+	}
+	s, err := t.createStream(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer t.deleteStream(s)
-	if err := s.send(timeoutCtx, b); err != nil {
+	if err := s.send(messageTypeRequest, 0, b); err != nil {
 		return nil, err
 	}
-	select {
-	case <-timeoutCtx.Done():
-		return nil, status.Canceled.Err()
-	case <-t.ctx.Done():
-		return nil, status.Canceled.Err()
-	case msg, ok := <-s.recv:
-		if !ok {
-			return nil, status.Unavailable.Err()
+	for {
+		if msg, ok, _ := s.recv.pop(); ok {
+			if msg.response == nil {
+				return nil, status.Internal.Err()
+			}
+			return msg.response, nil
+		}
+		select {
+		case <-ctx.Done():
+			// Let the server stop the handler, nobody reads its response.
+			_ = s.send(messageTypeCancel, flagNoData, nil)
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-t.ctx.Done():
+			return nil, status.Canceled.Err()
+		case <-s.recvClose:
+			return nil, s.recvErr
+		case <-s.recv.ready:
 		}
-		return msg, nil
 	}
 }
 
@@ -277,6 +806,12 @@ func (t *roundtrip) Close() error {
 	if t.closed != nil {
 		t.closed()
 	}
+	t.setState(connectivity.Shutdown, nil)
+	t.Lock()
+	if t.c != nil {
+		_ = t.c.Close()
+	}
+	t.Unlock()
 	t.cleanupStreams()
 	return nil
 }
@@ -2,9 +2,12 @@ package roundtrip
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
-	"path"
-	"slices"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/vimcoders/grpcx/status"
@@ -25,17 +28,30 @@ type ServerOption func(c *ServerOptions)
 // ServerOptions is a struct that holds the options for a ttrpc server.
 type ServerOptions struct {
 	encoding.Codec
-	desc        *grpc.ServiceDesc
-	imp         any
-	interceptor grpc.UnaryServerInterceptor
+	services          map[string]*serviceInfo
+	interceptor       grpc.UnaryServerInterceptor
+	streamInterceptor grpc.StreamServerInterceptor
+	// maxConcurrentStreams bounds the calls served at once on a connection.
+	maxConcurrentStreams uint32
+	maxRecvMsgSize       int
+	maxSendMsgSize       int
 }
 
+// defaultMaxConcurrentStreams is the default maximum number of calls served concurrently per connection.
+const defaultMaxConcurrentStreams = 1024
+
 // DefaultServerOptions is the default options for a ttrpc server.
 var DefaultServerOptions = ServerOptions{
-	Codec: encoding.GetCodec(encoding.Name),
+	Codec:                encoding.GetCodec(encoding.Name),
+	maxConcurrentStreams: defaultMaxConcurrentStreams,
+	maxRecvMsgSize:       defaultMaxRecvMsgSize,
+	maxSendMsgSize:       defaultMaxSendMsgSize,
 	interceptor: func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
 		return handler(ctx, req)
 	},
+	streamInterceptor: func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, ss)
+	},
 }
 
 // NewServer creates a new ttrpc server with the given options.
@@ -45,11 +61,93 @@ func UnaryServerInterceptor(interceptor grpc.UnaryServerInterceptor) ServerOptio
 	}
 }
 
-// Codec sets the codec for the ttrpc server.
+// StreamServerInterceptor sets the interceptor wrapping every streaming call.
+func StreamServerInterceptor(interceptor grpc.StreamServerInterceptor) ServerOption {
+	return func(so *ServerOptions) {
+		so.streamInterceptor = interceptor
+	}
+}
+
+// serviceInfo wraps information about a registered service, its methods
+// and streams are looked up by name.
+type serviceInfo struct {
+	serviceImpl any
+	methods     map[string]*grpc.MethodDesc
+	streams     map[string]*grpc.StreamDesc
+}
+
+// RegisterService registers a service and its implementation. Services are
+// keyed by name, so any number of services can be hosted by one server, but
+// registering the same service twice panics. If ss is non-nil, its type is
+// checked to ensure it implements sd.HandlerType.
 func RegisterService(sd *grpc.ServiceDesc, ss any) ServerOption {
 	return func(so *ServerOptions) {
-		so.desc = sd
-		so.imp = ss
+		if ss != nil {
+			ht := reflect.TypeOf(sd.HandlerType).Elem()
+			st := reflect.TypeOf(ss)
+			if !st.Implements(ht) {
+				panic(fmt.Sprintf("roundtrip: RegisterService found the handler of type %v that does not satisfy %v", st, ht))
+			}
+		}
+		if _, ok := so.services[sd.ServiceName]; ok {
+			panic(fmt.Sprintf("roundtrip: RegisterService found duplicate service registration for %q", sd.ServiceName))
+		}
+		info := &serviceInfo{
+			serviceImpl: ss,
+			methods:     make(map[string]*grpc.MethodDesc, len(sd.Methods)),
+			streams:     make(map[string]*grpc.StreamDesc, len(sd.Streams)),
+		}
+		for i := range sd.Methods {
+			info.methods[sd.Methods[i].MethodName] = &sd.Methods[i]
+		}
+		for i := range sd.Streams {
+			info.streams[sd.Streams[i].StreamName] = &sd.Streams[i]
+		}
+		if so.services == nil {
+			so.services = make(map[string]*serviceInfo)
+		}
+		so.services[sd.ServiceName] = info
+	}
+}
+
+// lookup returns the service registered for the full method name
+// "/service/method" and the method name within that service.
+func (so *ServerOptions) lookup(fullMethod string) (*serviceInfo, string, error) {
+	sm := strings.TrimPrefix(fullMethod, "/")
+	pos := strings.LastIndex(sm, "/")
+	if pos < 0 {
+		return nil, "", status.Errorf(codes.Unimplemented, "malformed method name: %q", fullMethod)
+	}
+	service, method := sm[:pos], sm[pos+1:]
+	info, ok := so.services[service]
+	if !ok {
+		return nil, "", status.Errorf(codes.Unimplemented, "unknown service %v", service)
+	}
+	return info, method, nil
+}
+
+// MaxConcurrentStreams limits the number of calls served concurrently on each
+// connection, calls beyond the limit fail with ResourceExhausted. Zero means no
+// limit.
+func MaxConcurrentStreams(n uint32) ServerOption {
+	return func(so *ServerOptions) {
+		so.maxConcurrentStreams = n
+	}
+}
+
+// WithServerMaxRecvMsgSize sets the maximum size of a message the server can
+// receive, like WithMaxRecvMsgSize for a client.
+func WithServerMaxRecvMsgSize(n int) ServerOption {
+	return func(so *ServerOptions) {
+		so.maxRecvMsgSize = n
+	}
+}
+
+// WithServerMaxSendMsgSize sets the maximum size of a message the server can
+// send, like WithMaxSendMsgSize for a client.
+func WithServerMaxSendMsgSize(n int) ServerOption {
+	return func(so *ServerOptions) {
+		so.maxSendMsgSize = n
 	}
 }
 
@@ -67,74 +165,297 @@ func (so *ServerOptions) RoundTrip(ctx context.Context, req *api.Request) (*api.
 			Message: codes.OK.String(),
 		}, nil
 	}
-	idx := slices.IndexFunc(so.desc.Methods, func(v grpc.MethodDesc) bool {
-		return path.Join("/", so.desc.ServiceName, v.MethodName) == req.Method
-	})
-	if idx < 0 {
-		return &api.Response{
-			Code:    int32(codes.Unimplemented),
-			Message: codes.Unimplemented.String(),
-		}, nil
+	info, name, err := so.lookup(req.Method)
+	if err != nil {
+		return errorResponse(err), nil
+	}
+	md, ok := info.methods[name]
+	if !ok {
+		return errorResponse(status.Errorf(codes.Unimplemented, "unknown method %v", req.Method)), nil
 	}
-	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Millisecond)
+	timeoutCtx, cancel := withTimeout(ctx, req.Timeout)
 	defer cancel()
-	reply, err := so.desc.Methods[idx].Handler(
-		so.imp,
-		metadata.WithMetadata(timeoutCtx, metadata.Pairs(req.Metadatas...)),
+	call := &callMetadata{method: req.Method}
+	reply, err := md.Handler(
+		info.serviceImpl,
+		grpc.NewContextWithServerTransportStream(metadata.WithMetadata(timeoutCtx, metadata.Pairs(req.Metadatas...)), call),
 		func(in any) error {
 			return so.Unmarshal(req.Payload, in)
 		},
 		so.interceptor)
 	if err != nil {
-		return &api.Response{
-			Code:    int32(codes.Unavailable),
-			Message: err.Error(),
-		}, nil
+		// Once the deadline has passed the client gave up on the call,
+		// whatever the handler returned.
+		if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+			err = status.DeadlineExceeded.Err()
+		}
+		return call.finish(errorResponse(err)), nil
 	}
 	response, err := so.Marshal(reply)
 	if err != nil {
-		return &api.Response{
-			Code:    int32(codes.Unavailable),
-			Message: err.Error(),
-		}, nil
+		return call.finish(errorResponse(status.Errorf(codes.Internal, "error while marshaling: %v", err))), nil
 	}
-	return &api.Response{
+	return call.finish(&api.Response{
 		Code:    int32(codes.OK),
 		Message: codes.OK.String(),
 		Payload: response,
-	}, nil
+	}), nil
 }
 
-// Handle handles incoming requests on the given net.Conn. It reads requests from the connection, dispatches them to the appropriate service method, and writes responses back to the connection.
+// Handle handles incoming requests on the given net.Conn. It reads requests from the connection and dispatches each of them to the appropriate service method in its own goroutine, so a slow call does not hold up the other streams of the connection. Responses are written back to the connection as the calls complete.
 func (so *ServerOptions) Handle(ctx context.Context, c net.Conn) (err error) {
 	defer c.Close()
+	sc := &serverConn{
+		ServerOptions: so,
+		channel:       newChannel(c, so.maxRecvMsgSize, so.maxSendMsgSize),
+		calls:         make(map[uint32]*serverCall),
+	}
+	if so.maxConcurrentStreams > 0 {
+		sc.sem = make(chan struct{}, so.maxConcurrentStreams)
+	}
+	defer sc.wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	codec := encoding.GetCodec(encoding.Name)
-	channel := newChannel(c)
 	for {
 		select {
 		case <-ctx.Done():
 			return status.Canceled.Err()
 		default:
-			streamID, payload, err := channel.Recv()
+			mh, payload, err := sc.channel.Recv()
 			if err != nil {
-				return err
+				if _, ok := status.FromError(err); !ok {
+					return err
+				}
+				// The message was discarded, fail the stream it belongs to.
+				if err := sc.send(mh.StreamID, errorResponse(err)); err != nil {
+					return err
+				}
+				sc.cancelCall(mh.StreamID)
+				continue
 			}
-			var request api.Request
-			if err := codec.Unmarshal(payload, &request); err != nil {
-				return err
-			}
-			channel.putmbuf(payload)
-			response, err := so.RoundTrip(ctx, &request)
-			if err != nil {
-				return err
-			}
-			b, err := codec.Marshal(response)
-			if err != nil {
-				return err
-			}
-			if err := channel.Send(streamID, b); err != nil {
-				return err
+			switch mh.Type {
+			case messageTypeRequest:
+				var request api.Request
+				if err := codec.Unmarshal(payload, &request); err != nil {
+					return err
+				}
+				sc.channel.putmbuf(payload)
+				if !sc.acquire() {
+					if err := sc.send(mh.StreamID, errorResponse(status.Errorf(codes.ResourceExhausted, "max concurrent streams (%d) exceeded", so.maxConcurrentStreams))); err != nil {
+						return err
+					}
+					continue
+				}
+				if mh.Flags&flagRemoteOpen != 0 {
+					if err := sc.openStream(ctx, mh.StreamID, &request); err != nil {
+						return err
+					}
+					continue
+				}
+				callCtx, cancel := context.WithCancel(ctx)
+				call := sc.startCall(mh.StreamID, cancel, nil)
+				sc.wg.Go(func() {
+					defer cancel()
+					response, err := so.RoundTrip(callCtx, &request)
+					sc.release()
+					// Nobody waits for the response of a canceled call.
+					if !sc.finishCall(mh.StreamID, call) || err != nil {
+						return
+					}
+					sc.sendResponse(mh.StreamID, response)
+				})
+			case messageTypeData:
+				ss := sc.getStream(mh.StreamID)
+				if ss == nil {
+					sc.channel.putmbuf(payload)
+					continue
+				}
+				_ = ss.receive(mh, payload)
+			case messageTypeWindowUpdate:
+				if ss := sc.getStream(mh.StreamID); ss != nil {
+					ss.sendWindow.add(payload)
+				}
+				sc.channel.putmbuf(payload)
+			case messageTypeCancel:
+				sc.channel.putmbuf(payload)
+				sc.cancelCall(mh.StreamID)
+			default:
+				sc.channel.putmbuf(payload)
 			}
 		}
 	}
 }
+
+// serverConn holds the state of a single connection served by Handle.
+type serverConn struct {
+	*ServerOptions
+	channel *channel
+	// calls holds the calls in flight by stream ID.
+	calls map[uint32]*serverCall
+	sync.Mutex
+	// sem holds a token for every call in flight, it is nil when the number of concurrent calls is not limited.
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+// acquire reserves a slot for a new call. It returns false if the connection
+// already serves the maximum number of concurrent calls.
+func (sc *serverConn) acquire() bool {
+	if sc.sem == nil {
+		return true
+	}
+	select {
+	case sc.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees the slot reserved by acquire.
+func (sc *serverConn) release() {
+	if sc.sem != nil {
+		<-sc.sem
+	}
+}
+
+// send sends the response finishing the given stream.
+func (sc *serverConn) send(streamID uint32, response *api.Response) error {
+	b, err := encoding.GetCodec(encoding.Name).Marshal(response)
+	if err != nil {
+		return err
+	}
+	return sc.channel.Send(streamID, messageTypeResponse, 0, b)
+}
+
+// sendResponse sends the response finishing the given stream. A response which
+// cannot be sent, e.g. larger than the maximum message size, fails the call
+// with the error instead, so the client does not wait for it.
+func (sc *serverConn) sendResponse(streamID uint32, response *api.Response) {
+	if err := sc.send(streamID, response); err != nil {
+		_ = sc.send(streamID, errorResponse(err))
+	}
+}
+
+// serverCall is a call in flight on a connection.
+type serverCall struct {
+	cancel context.CancelFunc
+	stream *serverStream // nil for unary calls
+}
+
+// startCall registers the call served on the given stream ID.
+func (sc *serverConn) startCall(sid uint32, cancel context.CancelFunc, ss *serverStream) *serverCall {
+	call := &serverCall{
+		cancel: cancel,
+		stream: ss,
+	}
+	sc.Lock()
+	defer sc.Unlock()
+	sc.calls[sid] = call
+	return call
+}
+
+// finishCall removes the call once it is done. It returns false if the call
+// was canceled by the client in the meantime, the stream ID may already be in
+// use by another call then.
+func (sc *serverConn) finishCall(sid uint32, call *serverCall) bool {
+	sc.Lock()
+	defer sc.Unlock()
+	if sc.calls[sid] != call {
+		return false
+	}
+	delete(sc.calls, sid)
+	return true
+}
+
+// cancelCall cancels the context of the call served on the given stream ID
+// and forgets it, its handler's response is never sent.
+func (sc *serverConn) cancelCall(sid uint32) {
+	sc.Lock()
+	call, ok := sc.calls[sid]
+	delete(sc.calls, sid)
+	sc.Unlock()
+	if ok {
+		call.cancel()
+	}
+}
+
+// getStream returns the open stream with the given stream ID. It returns nil if the stream does not exist.
+func (sc *serverConn) getStream(sid uint32) *serverStream {
+	sc.Lock()
+	defer sc.Unlock()
+	if call, ok := sc.calls[sid]; ok {
+		return call.stream
+	}
+	return nil
+}
+
+// openStream starts the stream handler for the given request in its own
+// goroutine. The stream is finished with a response carrying the status the
+// handler returned. The slot acquired for the call is released once the
+// stream is finished.
+func (sc *serverConn) openStream(ctx context.Context, streamID uint32, req *api.Request) error {
+	info, name, err := sc.lookup(req.Method)
+	if err != nil {
+		sc.release()
+		return sc.send(streamID, errorResponse(err))
+	}
+	desc, ok := info.streams[name]
+	if !ok {
+		sc.release()
+		return sc.send(streamID, errorResponse(status.Errorf(codes.Unimplemented, "unknown method %v", req.Method)))
+	}
+	ctx, cancel := withTimeout(metadata.WithMetadata(ctx, metadata.Pairs(req.Metadatas...)), req.Timeout)
+	ss := newServerStream(ctx, cancel, streamID, req.Method, sc.channel, sc.Codec)
+	call := sc.startCall(streamID, cancel, ss)
+	sc.wg.Go(func() {
+		defer cancel()
+		serverInfo := &grpc.StreamServerInfo{
+			FullMethod:     req.Method,
+			IsClientStream: desc.ClientStreams,
+			IsServerStream: desc.ServerStreams,
+		}
+		err := sc.streamInterceptor(info.serviceImpl, ss, serverInfo, desc.Handler)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = status.DeadlineExceeded.Err()
+		}
+		sc.release()
+		if !sc.finishCall(streamID, call) {
+			return
+		}
+		sc.sendResponse(streamID, ss.md.finish(errorResponse(err)))
+	})
+	return nil
+}
+
+// withTimeout bounds ctx by the timeout in milliseconds sent by the client,
+// zero means the call has no deadline.
+func withTimeout(ctx context.Context, timeout int64) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+}
+
+// errorResponse returns the response finishing a call with the given error.
+// The code, message and details of a status error are preserved, context
+// errors are mapped to their codes and any other error to Unknown.
+func errorResponse(err error) *api.Response {
+	if err == nil {
+		return &api.Response{
+			Code:    int32(codes.OK),
+			Message: codes.OK.String(),
+		}
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		s = status.FromContextError(err)
+	}
+	p := s.Proto()
+	return &api.Response{
+		Code:    p.Code,
+		Message: p.Message,
+		Details: p.Details,
+	}
+}
@@ -18,55 +18,481 @@ package roundtrip
 
 import (
 	"context"
+	"io"
 	"sync"
 
+	"github.com/vimcoders/grpcx/status"
+
 	"github.com/vimcoders/grpcx/generated/api"
 
 	"github.com/vimcoders/grpcx/encoding"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmetadata "google.golang.org/grpc/metadata"
 )
 
-// stream is a wrapper around grpc.ClientStream that provides methods for sending and receiving messages with a fixed-length header.
+// streamMessage is a single message received on a stream. Response messages
+// are decoded by the receive loop, data messages are handed over as is.
+type streamMessage struct {
+	header   messageHeader
+	response *api.Response
+	payload  []byte
+}
+
+// stream is the client side of a call multiplexed on a roundtrip connection.
+// Unary calls only wait for the response, streaming calls use it as a
+// grpc.ClientStream.
 type stream struct {
-	grpc.ClientStream
 	id     uint32
 	sender Sender
-	recv   chan *api.Response
+	recv   *messageQueue[*streamMessage]
 	encoding.Codec
+	// sendWindow bounds the data messages sent, recvWindow counts those
+	// received which were taken.
+	sendWindow *sendWindow
+	recvWindow recvWindow
+
+	ctx  context.Context
+	desc *grpc.StreamDesc
+	opts []grpc.CallOption
+
+	header     grpcmetadata.MD
+	headerOnce sync.Once
+	headerDone chan struct{}
+	trailer    grpcmetadata.MD
 
 	closeOnce sync.Once
+	recvErr   error
+	recvClose chan struct{}
 }
 
 // newStream creates a new stream with the given id and sender.
-func newStream(id uint32, send Sender) *stream {
+func newStream(ctx context.Context, id uint32, send Sender) *stream {
 	return &stream{
-		id:     id,
-		sender: send,
-		recv:   make(chan *api.Response, 1),
-		Codec:  encoding.GetCodec(encoding.Name),
+		id:         id,
+		sender:     send,
+		recv:       newMessageQueue[*streamMessage](),
+		Codec:      encoding.GetCodec(encoding.Name),
+		sendWindow: newSendWindow(),
+		ctx:        ctx,
+		headerDone: make(chan struct{}),
+		recvClose:  make(chan struct{}),
 	}
 }
 
 // close closes the stream and releases any resources associated with it.
 func (s *stream) close() error {
-	s.closeOnce.Do(func() { close(s.recv) })
+	return s.closeWithError(status.Unavailable.Err())
+}
+
+// closeWithError closes the stream, every later receive on it returns err.
+func (s *stream) closeWithError(err error) error {
+	return s.closeWithTrailer(err, nil)
+}
+
+// closeWithTrailer closes the stream like closeWithError, the trailer is kept
+// if the stream was not closed before.
+func (s *stream) closeWithTrailer(err error, trailer grpcmetadata.MD) error {
+	s.closeOnce.Do(func() {
+		s.recvErr = err
+		s.trailer = trailer
+		close(s.recvClose)
+	})
 	return nil
 }
 
 // send sends a message on the stream. The message is sent with a fixed-length header that includes the stream id.
-func (s *stream) send(_ context.Context, b []byte) error {
-	return s.sender.Send(s.id, b)
+func (s *stream) send(t messageType, flags uint8, b []byte) error {
+	return s.sender.Send(s.id, t, flags, b)
 }
 
-// receive receives a message from the stream. The message is received with a fixed-length header that includes the stream id. If the stream is closed, an error is returned.
-func (s *stream) receive(ctx context.Context, response *api.Response) error {
+// receive queues a message for the reader of the stream, it never blocks.
+func (s *stream) receive(msg *streamMessage) error {
 	select {
-	case s.recv <- response:
+	case <-s.recvClose:
+		return s.recvErr
+	default:
+	}
+	s.recv.put(msg)
+	return nil
+}
+
+// next returns the next message of the stream. The bytes of a data message
+// are granted back to the server.
+func (s *stream) next() (*streamMessage, error) {
+	for {
+		if msg, ok, _ := s.recv.pop(); ok {
+			if msg.header.Type == messageTypeData {
+				if p := s.recvWindow.take(len(msg.payload)); p != nil {
+					_ = s.send(messageTypeWindowUpdate, 0, p)
+				}
+			}
+			return msg, nil
+		}
+		select {
+		case <-s.recv.ready:
+		case <-s.recvClose:
+			return nil, s.recvErr
+		case <-s.ctx.Done():
+			return nil, status.FromContextError(s.ctx.Err()).Err()
+		}
+	}
+}
+
+// setHeader records the header metadata received from the server, only the
+// first header of a stream is kept.
+func (s *stream) setHeader(md grpcmetadata.MD) {
+	s.headerOnce.Do(func() {
+		s.header = md
+		close(s.headerDone)
+	})
+}
+
+// finish closes the stream with the status carried by the response.
+func (s *stream) finish(response *api.Response) error {
+	trailer := PairsMetadata(response.Trailers)
+	setCallMetadata(s.opts, s.header, trailer)
+	err := responseError(response)
+	if err == nil {
+		err = io.EOF
+	}
+	s.closeWithTrailer(err, trailer)
+	return err
+}
+
+// Header implements [grpc.ClientStream]. It blocks until the header is
+// received from the server or the stream fails.
+func (s *stream) Header() (grpcmetadata.MD, error) {
+	// The header wins over the stream being closed when both happened.
+	select {
+	case <-s.headerDone:
+		return s.header.Copy(), nil
+	default:
+	}
+	select {
+	case <-s.headerDone:
+		return s.header.Copy(), nil
+	case <-s.recvClose:
+		if s.recvErr == io.EOF {
+			return nil, nil
+		}
+		return nil, s.recvErr
+	case <-s.ctx.Done():
+		return nil, status.FromContextError(s.ctx.Err()).Err()
+	}
+}
+
+// Trailer implements [grpc.ClientStream]. It returns the trailer once the
+// stream is finished, nil before.
+func (s *stream) Trailer() grpcmetadata.MD {
+	select {
+	case <-s.recvClose:
+		return s.trailer.Copy()
+	default:
 		return nil
-	case <-ctx.Done():
-		return s.close()
+	}
+}
+
+// CloseSend implements [grpc.ClientStream]. It half-closes the stream, the
+// server sees io.EOF once it has read every message sent before.
+func (s *stream) CloseSend() error {
+	return s.send(messageTypeData, flagRemoteClosed|flagNoData, nil)
+}
+
+// Context implements [grpc.ClientStream].
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg implements [grpc.ClientStream]. It blocks while the server has not
+// taken enough of the messages sent before.
+func (s *stream) SendMsg(m any) error {
+	select {
+	case <-s.recvClose:
+		return io.EOF
 	default:
-		return s.close()
+	}
+	b, err := s.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if !s.sendWindow.acquire(len(b), s.recvClose, s.ctx.Done()) {
+		if err := s.ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		return io.EOF
+	}
+	return s.send(messageTypeData, 0, b)
+}
+
+// RecvMsg implements [grpc.ClientStream]. It returns io.EOF once the server
+// has finished the stream with an OK status.
+func (s *stream) RecvMsg(m any) error {
+	msg, err := s.next()
+	if err != nil {
+		return err
+	}
+	if msg.header.Type == messageTypeResponse {
+		return s.finish(msg.response)
+	}
+	if err := s.Unmarshal(msg.payload, m); err != nil {
+		return err
+	}
+	if s.desc.ServerStreams {
+		return nil
+	}
+	// A non server streaming call has exactly one message followed by the
+	// status of the call.
+	msg, err = s.next()
+	if err != nil {
+		return err
+	}
+	if msg.header.Type != messageTypeResponse {
+		return status.Error(codes.Internal, "cardinality violation: expected <EOF> for non server-streaming RPCs")
+	}
+	if err := s.finish(msg.response); err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// serverStream is the server side of a streaming call. It implements
+// grpc.ServerStream on top of the connection the call arrived on.
+type serverStream struct {
+	id     uint32
+	sender Sender
+	recv   *messageQueue[[]byte]
+	encoding.Codec
+	// sendWindow bounds the data messages sent, recvWindow counts those
+	// received which were taken.
+	sendWindow *sendWindow
+	recvWindow recvWindow
+
+	ctx          context.Context
+	cancel       context.CancelFunc
+	remoteClosed bool
+	md           *callMetadata
+}
+
+// newServerStream creates a new server stream with the given id and sender.
+// The cancel function aborts the handler serving the stream.
+func newServerStream(ctx context.Context, cancel context.CancelFunc, id uint32, method string, send Sender, codec encoding.Codec) *serverStream {
+	ss := &serverStream{
+		id:         id,
+		sender:     send,
+		recv:       newMessageQueue[[]byte](),
+		Codec:      codec,
+		cancel:     cancel,
+		sendWindow: newSendWindow(),
+	}
+	ss.md = &callMetadata{
+		method: method,
+		send:   ss.sendHeader,
+	}
+	ss.ctx = grpc.NewContextWithServerTransportStream(ctx, ss.md)
+	return ss
+}
+
+// sendHeader writes the header metadata to the client.
+func (ss *serverStream) sendHeader(md grpcmetadata.MD) error {
+	b, err := encoding.GetCodec(encoding.Name).Marshal(&api.Response{
+		Headers: metadataPairs(md),
+	})
+	if err != nil {
+		return err
+	}
+	return ss.sender.Send(ss.id, messageTypeHeader, 0, b)
+}
+
+// receive queues a data message for the handler, it never blocks. Only the
+// connection reading goroutine may call receive.
+func (ss *serverStream) receive(mh messageHeader, p []byte) error {
+	if ss.remoteClosed {
+		return status.FailedPrecondition.Err()
+	}
+	if mh.Flags&flagNoData == 0 {
+		ss.recv.put(p)
+	}
+	if mh.Flags&flagRemoteClosed != 0 {
+		ss.remoteClosed = true
+		ss.recv.close()
+	}
+	return nil
+}
+
+// SetHeader implements [grpc.ServerStream].
+func (ss *serverStream) SetHeader(md grpcmetadata.MD) error {
+	return ss.md.SetHeader(md)
+}
+
+// SendHeader implements [grpc.ServerStream].
+func (ss *serverStream) SendHeader(md grpcmetadata.MD) error {
+	return ss.md.SendHeader(md)
+}
+
+// SetTrailer implements [grpc.ServerStream].
+func (ss *serverStream) SetTrailer(md grpcmetadata.MD) {
+	_ = ss.md.SetTrailer(md)
+}
+
+// Context implements [grpc.ServerStream].
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+// SendMsg implements [grpc.ServerStream]. It blocks while the client has not
+// taken enough of the messages sent before.
+func (ss *serverStream) SendMsg(m any) error {
+	if err := ss.ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	b, err := ss.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := ss.md.flushHeader(); err != nil {
+		return err
+	}
+	if !ss.sendWindow.acquire(len(b), nil, ss.ctx.Done()) {
+		return status.FromContextError(ss.ctx.Err()).Err()
+	}
+	return ss.sender.Send(ss.id, messageTypeData, 0, b)
+}
+
+// RecvMsg implements [grpc.ServerStream]. It returns io.EOF once the client
+// has half-closed the stream. The bytes of the message are granted back to the
+// client.
+func (ss *serverStream) RecvMsg(m any) error {
+	for {
+		p, ok, closed := ss.recv.pop()
+		if ok {
+			if update := ss.recvWindow.take(len(p)); update != nil {
+				_ = ss.sender.Send(ss.id, messageTypeWindowUpdate, 0, update)
+			}
+			return ss.Unmarshal(p, m)
+		}
+		if closed {
+			return io.EOF
+		}
+		select {
+		case <-ss.recv.ready:
+		case <-ss.ctx.Done():
+			return status.FromContextError(ss.ctx.Err()).Err()
+		}
+	}
+}
+
+// callMetadata collects the header and trailer metadata a handler sets for
+// the response of a call. It is attached to the handler's context, so that
+// grpc.SetHeader, grpc.SendHeader and grpc.SetTrailer work with roundtrip.
+type callMetadata struct {
+	sync.Mutex
+	method     string
+	header     grpcmetadata.MD
+	trailer    grpcmetadata.MD
+	headerSent bool
+	// send writes the header to the client. It is nil for unary calls, whose
+	// header travels with the response.
+	send func(grpcmetadata.MD) error
+}
+
+// Method implements [grpc.ServerTransportStream].
+func (c *callMetadata) Method() string {
+	return c.method
+}
+
+// SetHeader implements [grpc.ServerTransportStream].
+func (c *callMetadata) SetHeader(md grpcmetadata.MD) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.headerSent {
+		return status.Error(codes.Internal, "transport: the header was already sent")
+	}
+	c.header = grpcmetadata.Join(c.header, md)
+	return nil
+}
+
+// SendHeader implements [grpc.ServerTransportStream].
+func (c *callMetadata) SendHeader(md grpcmetadata.MD) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.headerSent {
+		return status.Error(codes.Internal, "transport: the header was already sent")
+	}
+	c.header = grpcmetadata.Join(c.header, md)
+	return c.sendHeader()
+}
+
+// SetTrailer implements [grpc.ServerTransportStream].
+func (c *callMetadata) SetTrailer(md grpcmetadata.MD) error {
+	c.Lock()
+	defer c.Unlock()
+	c.trailer = grpcmetadata.Join(c.trailer, md)
+	return nil
+}
+
+// flushHeader sends the header unless it was sent already.
+func (c *callMetadata) flushHeader() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.headerSent {
+		return nil
+	}
+	return c.sendHeader()
+}
+
+// sendHeader marks the header as sent and writes it to the client.
+func (c *callMetadata) sendHeader() error {
+	c.headerSent = true
+	if c.send == nil {
+		return nil
+	}
+	return c.send(c.header)
+}
+
+// finish stores the metadata which was not sent yet into the response
+// finishing the call.
+func (c *callMetadata) finish(response *api.Response) *api.Response {
+	c.Lock()
+	defer c.Unlock()
+	if !c.headerSent || c.send == nil {
+		response.Headers = metadataPairs(c.header)
+	}
+	c.headerSent = true
+	response.Trailers = metadataPairs(c.trailer)
+	return response
+}
+
+// metadataPairs flattens md into key value pairs as carried on the wire.
+func metadataPairs(md grpcmetadata.MD) []string {
+	var kv []string
+	for k, vs := range md {
+		for _, v := range vs {
+			kv = append(kv, k, v)
+		}
+	}
+	return kv
+}
+
+// PairsMetadata rebuilds the metadata from the key value pairs carried on
+// the wire. A trailing key without value is dropped.
+func PairsMetadata(kv []string) grpcmetadata.MD {
+	if len(kv) == 0 {
+		return nil
+	}
+	return grpcmetadata.Pairs(kv[:len(kv)&^1]...)
+}
+
+// setCallMetadata fills the grpc.Header and grpc.Trailer call options with
+// the metadata received from the server.
+func setCallMetadata(opts []grpc.CallOption, header, trailer grpcmetadata.MD) {
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = header
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = trailer
+		}
 	}
 }
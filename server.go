@@ -2,19 +2,32 @@ package grpcx
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
 
 	"github.com/vimcoders/grpcx/roundtrip"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// ErrHealthReplaced is returned by the methods setting the serving status of
+// a server whose health service was replaced by another implementation, see
+// Server.RegisterService. The status is then up to that implementation.
+var ErrHealthReplaced = errors.New("grpcx: health service replaced")
+
 type Server struct {
 	roundtrip.ServerOptions
 	wg       sync.WaitGroup
 	listener net.Listener
 	closed   context.CancelFunc
+	// health serves the grpc.health.v1.Health service unless another
+	// implementation was registered. It is registered by the first Serve.
+	health           *health.Server
+	healthReplaced   bool
+	healthRegistered bool
 }
 
 func NewServer(opt ...roundtrip.ServerOption) *Server {
@@ -24,6 +37,7 @@ func NewServer(opt ...roundtrip.ServerOption) *Server {
 	}
 	return &Server{
 		ServerOptions: opts,
+		health:        health.NewServer(),
 	}
 }
 
@@ -31,8 +45,49 @@ func NewServer(opt ...roundtrip.ServerOption) *Server {
 // server. It is called from the IDL generated code. This must be called before
 // invoking Serve. If ss is non-nil (for legacy code), its type is checked to
 // ensure it implements sd.HandlerType.
+//
+// The service is reported SERVING by the health service of the server,
+// registering another implementation of grpc.health.v1.Health replaces it.
 func (s *Server) RegisterService(sd *grpc.ServiceDesc, ss any) {
 	roundtrip.RegisterService(sd, ss)(&s.ServerOptions)
+	if sd.ServiceName == healthpb.Health_ServiceDesc.ServiceName {
+		s.healthReplaced = true
+		return
+	}
+	s.health.SetServingStatus(sd.ServiceName, healthpb.HealthCheckResponse_SERVING)
+}
+
+// SetServingStatus sets the serving status of a service reported by the
+// health service of the server, the empty service is the whole server.
+// Balancers stop picking backends which are NOT_SERVING. It returns
+// ErrHealthReplaced if another health service was registered.
+func (s *Server) SetServingStatus(service string, servingStatus healthpb.HealthCheckResponse_ServingStatus) error {
+	if s.healthReplaced {
+		return ErrHealthReplaced
+	}
+	s.health.SetServingStatus(service, servingStatus)
+	return nil
+}
+
+// Drain reports all the services NOT_SERVING, until Resume, so that clients
+// stop sending new calls before the server is closed. Calls are still served.
+// It returns ErrHealthReplaced if another health service was registered.
+func (s *Server) Drain() error {
+	if s.healthReplaced {
+		return ErrHealthReplaced
+	}
+	s.health.Shutdown()
+	return nil
+}
+
+// Resume reports all the services SERVING again after Drain. It returns
+// ErrHealthReplaced if another health service was registered.
+func (s *Server) Resume() error {
+	if s.healthReplaced {
+		return ErrHealthReplaced
+	}
+	s.health.Resume()
+	return nil
 }
 
 func (s *Server) Close() error {
@@ -46,6 +101,8 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// ListenAndServe listens on the TCP network address addr and then calls Serve
+// to handle incoming connections.
 func (s *Server) ListenAndServe(ctx context.Context, addr string, opt ...roundtrip.ServerOption) error {
 	for i := range opt {
 		opt[i](&s.ServerOptions)
@@ -54,6 +111,16 @@ func (s *Server) ListenAndServe(ctx context.Context, addr string, opt ...roundtr
 	if err != nil {
 		return err
 	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts incoming connections on the listener, serving each one in its
+// own goroutine. Serve always returns a non-nil error and closes the server.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	if !s.healthReplaced && !s.healthRegistered {
+		roundtrip.RegisterService(&healthpb.Health_ServiceDesc, s.health)(&s.ServerOptions)
+		s.healthRegistered = true
+	}
 	s.listener = listener
 	cancelCtx, closed := context.WithCancel(ctx)
 	s.closed = closed
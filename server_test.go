@@ -0,0 +1,379 @@
+package grpcx_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestServer starts a server on a random local port and returns its address.
+func newTestServer(t *testing.T, register func(s *grpcx.Server), opts ...roundtrip.ServerOption) string {
+	t.Helper()
+	return serveTestServer(t, listen(t, "tcp", "127.0.0.1:0"), register, opts...)
+}
+
+// listen listens on the address, the listener is closed when the test finishes.
+func listen(t *testing.T, network, address string) net.Listener {
+	t.Helper()
+	lis, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = lis.Close()
+	})
+	return lis
+}
+
+// serveTestServer serves on lis and returns its address. The server is closed
+// when the test finishes.
+func serveTestServer(t *testing.T, lis net.Listener, register func(s *grpcx.Server), opts ...roundtrip.ServerOption) string {
+	t.Helper()
+	tl := &trackingListener{Listener: lis}
+	s := grpcx.NewServer(opts...)
+	register(s)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		_ = s.Serve(context.Background(), tl)
+	}()
+	// Serve waits for the connections once the listener is closed, so they
+	// are broken first.
+	t.Cleanup(func() {
+		_ = tl.Close()
+		tl.closeConns()
+		<-served
+	})
+	return lis.Addr().String()
+}
+
+func TestRegisterMultipleServices(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+		healthpb.RegisterHealthServer(s, health.NewServer())
+	})
+	c, err := grpcx.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	resp, err := api.NewEchoServiceClient(c).Echo(ctx, &api.EchoRequest{Message: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message != "hello" {
+		t.Fatalf("got %q, want %q", resp.Message, "hello")
+	}
+	check, err := healthpb.NewHealthClient(c).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("got %v, want %v", check.Status, healthpb.HealthCheckResponse_SERVING)
+	}
+	var out api.EchoResponse
+	err = c.Invoke(ctx, "/api.UnknownService/Echo", &api.EchoRequest{}, &out)
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("got %v, want %v", err, codes.Unimplemented)
+	}
+}
+
+func TestRegisterDuplicateService(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a service twice did not panic")
+		}
+	}()
+	s := grpcx.NewServer()
+	api.RegisterEchoServiceServer(s, &TTHandler{})
+	api.RegisterEchoServiceServer(s, &TTHandler{})
+}
+
+// slowHandler blocks every call asking for "slow" until release is closed.
+type slowHandler struct {
+	api.UnimplementedEchoServiceServer
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newSlowHandler() *slowHandler {
+	return &slowHandler{
+		entered: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+}
+
+func (h *slowHandler) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	if req.Message == "slow" {
+		h.entered <- struct{}{}
+		select {
+		case <-h.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &api.EchoResponse{Message: req.Message}, nil
+}
+
+func TestSlowMethodDoesNotBlockConnection(t *testing.T) {
+	h := newSlowHandler()
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, h)
+	})
+	// A single roundtrip transport multiplexes both calls on one connection.
+	rt, err := roundtrip.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	slow := make(chan error, 1)
+	go func() {
+		_, err := client.Echo(context.Background(), &api.EchoRequest{Message: "slow"})
+		slow <- err
+	}()
+	<-h.entered
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := client.Echo(ctx, &api.EchoRequest{Message: "fast"})
+	if err != nil {
+		t.Fatalf("fast call blocked behind slow call: %v", err)
+	}
+	if resp.Message != "fast" {
+		t.Fatalf("got %q, want %q", resp.Message, "fast")
+	}
+	select {
+	case err := <-slow:
+		t.Fatalf("slow call finished before it was released: %v", err)
+	default:
+	}
+	close(h.release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	h := newSlowHandler()
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, h)
+	}, roundtrip.MaxConcurrentStreams(1))
+	rt, err := roundtrip.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	slow := make(chan error, 1)
+	go func() {
+		_, err := client.Echo(context.Background(), &api.EchoRequest{Message: "slow"})
+		slow <- err
+	}()
+	<-h.entered
+	_, err = client.Echo(context.Background(), &api.EchoRequest{Message: "fast"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want %v", err, codes.ResourceExhausted)
+	}
+	close(h.release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Echo(context.Background(), &api.EchoRequest{Message: "fast"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLargeMessage(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+	}, roundtrip.WithServerMaxRecvMsgSize(8<<20))
+	c, err := grpcx.Dial(addr, grpcx.WithMaxRecvMsgSize(8<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := api.NewEchoServiceClient(c)
+	message := strings.Repeat("x", 5<<20)
+	resp, err := client.Echo(context.Background(), &api.EchoRequest{Message: message})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message != message {
+		t.Fatalf("got %d bytes, want %d", len(resp.Message), len(message))
+	}
+	stream, err := client.BidirectionalStreamingEcho(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&api.EchoRequest{Message: message}); err != nil {
+		t.Fatal(err)
+	}
+	streamResp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamResp.Message != message {
+		t.Fatalf("got %d bytes, want %d", len(streamResp.Message), len(message))
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxMsgSize(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &TTHandler{})
+	}, roundtrip.WithServerMaxRecvMsgSize(1<<20), roundtrip.WithServerMaxSendMsgSize(4<<10))
+	c, err := grpcx.Dial(addr, grpcx.WithMaxSendMsgSize(2<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := api.NewEchoServiceClient(c)
+	ctx := context.Background()
+	// Rejected by the server, the connection stays usable.
+	_, err = client.Echo(ctx, &api.EchoRequest{Message: strings.Repeat("x", 1<<20+1)})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want %v", err, codes.ResourceExhausted)
+	}
+	// Rejected by the client before it is sent.
+	_, err = client.Echo(ctx, &api.EchoRequest{Message: strings.Repeat("x", 2<<20+1)})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want %v", err, codes.ResourceExhausted)
+	}
+	// The response is too large for the server to send, the call fails
+	// without waiting for a deadline.
+	_, err = client.Echo(ctx, &api.EchoRequest{Message: strings.Repeat("x", 4<<10)})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want %v", err, codes.ResourceExhausted)
+	}
+	stream, err := client.ServerStreamingEcho(ctx, &api.EchoRequest{Message: strings.Repeat("x", 4<<10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want %v", err, codes.ResourceExhausted)
+	}
+	if _, err := client.Echo(ctx, &api.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFragmentedMessagesBounded interleaves the fragments of two messages each
+// within the maximum size but larger than it together, the one exceeding it
+// is rejected without affecting the other.
+func TestFragmentedMessagesBounded(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {}, roundtrip.WithServerMaxRecvMsgSize(100<<10))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Frames are written by hand: a 10 byte header of length, stream ID,
+	// type (request 0x1) and flags (fragment 0x8), then the payload.
+	write := func(streamID uint32, flags uint8, p []byte) {
+		var hdr [10]byte
+		binary.BigEndian.PutUint32(hdr[:4], uint32(len(p)))
+		binary.BigEndian.PutUint32(hdr[4:8], streamID)
+		hdr[8], hdr[9] = 0x1, flags
+		if _, err := conn.Write(append(hdr[:], p...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() (uint32, codes.Code) {
+		var hdr [10]byte
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, binary.BigEndian.Uint32(hdr[:4]))
+		if _, err := io.ReadFull(conn, p); err != nil {
+			t.Fatal(err)
+		}
+		var response api.Response
+		if err := proto.Unmarshal(p, &response); err != nil {
+			t.Fatal(err)
+		}
+		return binary.BigEndian.Uint32(hdr[4:8]), codes.Code(response.Code)
+	}
+	request, err := proto.Marshal(&api.Request{Service: "api.EchoService", Method: "Echo", Payload: make([]byte, 70<<10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(1, 0x8, request[:60<<10])
+	write(3, 0x8, request[:60<<10])
+	write(1, 0, request[60<<10:])
+	write(3, 0, request[60<<10:])
+	want := map[uint32]codes.Code{1: codes.Unimplemented, 3: codes.ResourceExhausted}
+	for range want {
+		id, code := read()
+		if code != want[id] {
+			t.Fatalf("stream %d: got %v, want %v", id, code, want[id])
+		}
+	}
+}
+
+// errorHandler fails every call with the status it was given.
+type errorHandler struct {
+	api.UnimplementedEchoServiceServer
+	status *grpcstatus.Status
+}
+
+func (h *errorHandler) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	return nil, h.status.Err()
+}
+
+func (h *errorHandler) BidirectionalStreamingEcho(stream grpc.BidiStreamingServer[api.EchoRequest, api.EchoResponse]) error {
+	return h.status.Err()
+}
+
+func TestStatusPreserved(t *testing.T) {
+	want, err := grpcstatus.New(codes.InvalidArgument, "message must not be empty").WithDetails(&errdetails.ErrorInfo{
+		Reason: "EMPTY_MESSAGE",
+		Domain: "api.EchoService",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &errorHandler{status: want})
+	})
+	c, err := grpcx.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := api.NewEchoServiceClient(c)
+	_, err = client.Echo(context.Background(), &api.EchoRequest{})
+	if got := status.Convert(err); !proto.Equal(got.Proto(), want.Proto()) {
+		t.Fatalf("got %v, want %v", got.Proto(), want.Proto())
+	}
+	stream, err := client.BidirectionalStreamingEcho(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if got := status.Convert(err); !proto.Equal(got.Proto(), want.Proto()) {
+		t.Fatalf("got %v, want %v", got.Proto(), want.Proto())
+	}
+}
@@ -0,0 +1,78 @@
+package status
+
+import (
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ErrorWithDetails returns an error representing c and msg with the given
+// details attached, e.g. errdetails.BadRequest, errdetails.RetryInfo or
+// errdetails.QuotaFailure. The details travel to the client as
+// google.protobuf.Any entries.
+func ErrorWithDetails(c codes.Code, msg string, details ...proto.Message) error {
+	p := &spb.Status{
+		Code:    int32(c),
+		Message: msg,
+	}
+	for _, detail := range details {
+		a, err := anypb.New(detail)
+		if err != nil {
+			return status.Errorf(codes.Internal, "marshaling status details: %v", err)
+		}
+		p.Details = append(p.Details, a)
+	}
+	return status.FromProto(p).Err()
+}
+
+// Details returns the details of type T attached to the status of err.
+// Details which cannot be decoded or are of another type are skipped.
+func Details[T proto.Message](err error) []T {
+	s, ok := status.FromError(err)
+	if !ok {
+		return nil
+	}
+	var details []T
+	for _, detail := range s.Details() {
+		if v, ok := detail.(T); ok {
+			details = append(details, v)
+		}
+	}
+	return details
+}
+
+// RetryDelay returns the delay the server asked the client to wait before
+// retrying, as carried by errdetails.RetryInfo.
+func RetryDelay(err error) (time.Duration, bool) {
+	for _, info := range Details[*errdetails.RetryInfo](err) {
+		if info.RetryDelay != nil {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// FieldViolations returns the invalid request fields carried by
+// errdetails.BadRequest.
+func FieldViolations(err error) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, br := range Details[*errdetails.BadRequest](err) {
+		violations = append(violations, br.FieldViolations...)
+	}
+	return violations
+}
+
+// QuotaViolations returns the exceeded quotas carried by
+// errdetails.QuotaFailure.
+func QuotaViolations(err error) []*errdetails.QuotaFailure_Violation {
+	var violations []*errdetails.QuotaFailure_Violation
+	for _, qf := range Details[*errdetails.QuotaFailure](err) {
+		violations = append(violations, qf.Violations...)
+	}
+	return violations
+}
@@ -0,0 +1,48 @@
+package status_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/status"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestErrorWithDetails(t *testing.T) {
+	err := status.ErrorWithDetails(codes.ResourceExhausted, "slow down",
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(2 * time.Second)},
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{
+			{Subject: "user:1", Description: "too many requests"},
+		}},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "message", Description: "must not be empty"},
+		}},
+	)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want %v", status.Code(err), codes.ResourceExhausted)
+	}
+	if d, ok := status.RetryDelay(err); !ok || d != 2*time.Second {
+		t.Fatalf("got %v %v, want %v", d, ok, 2*time.Second)
+	}
+	if v := status.QuotaViolations(err); len(v) != 1 || v[0].Subject != "user:1" {
+		t.Fatalf("got %v", v)
+	}
+	if v := status.FieldViolations(err); len(v) != 1 || v[0].Field != "message" {
+		t.Fatalf("got %v", v)
+	}
+	if d := status.Details[*errdetails.ErrorInfo](err); len(d) != 0 {
+		t.Fatalf("got %v, want no details", d)
+	}
+}
+
+func TestRetryDelayWithoutDetails(t *testing.T) {
+	if _, ok := status.RetryDelay(status.Unavailable.Err()); ok {
+		t.Fatal("got a retry delay for a status without details")
+	}
+	if _, ok := status.RetryDelay(nil); ok {
+		t.Fatal("got a retry delay for a nil error")
+	}
+}
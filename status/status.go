@@ -1,6 +1,7 @@
 package status
 
 import (
+	spb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -38,3 +39,15 @@ func Code(err error) codes.Code {
 func Error(c codes.Code, msg string) error {
 	return status.Error(c, msg)
 }
+
+func Errorf(c codes.Code, format string, a ...any) error {
+	return status.Errorf(c, format, a...)
+}
+
+func FromContextError(err error) *status.Status {
+	return status.FromContextError(err)
+}
+
+func FromProto(s *spb.Status) *status.Status {
+	return status.FromProto(s)
+}
@@ -0,0 +1,219 @@
+package grpcx_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vimcoders/grpcx/roundtrip"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx"
+
+	"google.golang.org/grpc"
+)
+
+const streamCount = 3
+
+func (h *TTHandler) ServerStreamingEcho(req *api.EchoRequest, stream grpc.ServerStreamingServer[api.EchoResponse]) error {
+	for range streamCount {
+		if err := stream.Send(&api.EchoResponse{Message: req.Message}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *TTHandler) ClientStreamingEcho(stream grpc.ClientStreamingServer[api.EchoRequest, api.EchoResponse]) error {
+	var messages []string
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&api.EchoResponse{Message: strings.Join(messages, ",")})
+		}
+		if err != nil {
+			return err
+		}
+		messages = append(messages, req.Message)
+	}
+}
+
+func (h *TTHandler) BidirectionalStreamingEcho(stream grpc.BidiStreamingServer[api.EchoRequest, api.EchoResponse]) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&api.EchoResponse{Message: req.Message}); err != nil {
+			return err
+		}
+	}
+}
+
+func newEchoClient(t *testing.T) api.EchoServiceClient {
+	t.Helper()
+	c, err := grpcx.Dial(ttAddr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return api.NewEchoServiceClient(c)
+}
+
+func TestServerStreamingEcho(t *testing.T) {
+	client := newEchoClient(t)
+	stream, err := client.ServerStreamingEcho(context.Background(), &api.EchoRequest{Message: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Message != "hello" {
+			t.Fatalf("got %q, want %q", resp.Message, "hello")
+		}
+		n++
+	}
+	if n != streamCount {
+		t.Fatalf("got %d messages, want %d", n, streamCount)
+	}
+}
+
+func TestClientStreamingEcho(t *testing.T) {
+	client := newEchoClient(t)
+	stream, err := client.ClientStreamingEcho(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{"a", "b", "c"} {
+		if err := stream.Send(&api.EchoRequest{Message: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message != "a,b,c" {
+		t.Fatalf("got %q, want %q", resp.Message, "a,b,c")
+	}
+}
+
+func TestBidirectionalStreamingEcho(t *testing.T) {
+	client := newEchoClient(t)
+	stream, err := client.BidirectionalStreamingEcho(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{"a", "b", "c"} {
+		if err := stream.Send(&api.EchoRequest{Message: m}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Message != m {
+			t.Fatalf("got %q, want %q", resp.Message, m)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+// stalledHandler serves streams which never read what the client sends, or
+// flood the client, next to unary calls.
+type stalledHandler struct {
+	api.UnimplementedEchoServiceServer
+}
+
+func (h *stalledHandler) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	return &api.EchoResponse{Message: req.Message}, nil
+}
+
+func (h *stalledHandler) ServerStreamingEcho(req *api.EchoRequest, stream grpc.ServerStreamingServer[api.EchoResponse]) error {
+	for {
+		if err := stream.Send(&api.EchoResponse{Message: req.Message}); err != nil {
+			return err
+		}
+	}
+}
+
+func (h *stalledHandler) BidirectionalStreamingEcho(stream grpc.BidiStreamingServer[api.EchoRequest, api.EchoResponse]) error {
+	<-stream.Context().Done()
+	return stream.Context().Err()
+}
+
+func TestStalledStreamDoesNotBlockConnection(t *testing.T) {
+	addr := newTestServer(t, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &stalledHandler{})
+	})
+	// A single roundtrip transport multiplexes all the calls on one connection.
+	rt, err := roundtrip.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	client := api.NewEchoServiceClient(rt)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The server never reads this stream, the client sends until the window
+	// of the stream is exhausted.
+	stalled, err := client.BidirectionalStreamingEcho(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := strings.Repeat("x", 64*1024)
+	sent := make(chan int, 1)
+	go func() {
+		var n int
+		for stalled.Send(&api.EchoRequest{Message: payload}) == nil {
+			n++
+		}
+		sent <- n
+	}()
+	// The client never reads this stream, the server sends until the window
+	// of the stream is exhausted.
+	if _, err := client.ServerStreamingEcho(ctx, &api.EchoRequest{Message: payload}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for range 5 {
+		callCtx, callCancel := context.WithTimeout(context.Background(), time.Second)
+		resp, err := client.Echo(callCtx, &api.EchoRequest{Message: "fast"})
+		callCancel()
+		if err != nil {
+			t.Fatalf("unary call blocked by stalled streams: %v", err)
+		}
+		if resp.Message != "fast" {
+			t.Fatalf("got %q, want %q", resp.Message, "fast")
+		}
+	}
+	// Sends on the stalled stream block on its window, not on the connection.
+	select {
+	case n := <-sent:
+		t.Fatalf("send on stalled stream returned after %d messages", n)
+	default:
+	}
+	cancel()
+	if n := <-sent; n*len(payload) > 2*1024*1024 {
+		t.Fatalf("sent %d messages past the stream window", n)
+	}
+}
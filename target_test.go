@@ -0,0 +1,119 @@
+package grpcx_test
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/vimcoders/grpcx/generated/api"
+
+	"github.com/vimcoders/grpcx"
+)
+
+func TestDialTarget(t *testing.T) {
+	a, b := newBackendServer(t, "tcp", "127.0.0.1:0"), newBackendServer(t, "tcp", "127.0.0.1:0")
+	sock := newBackendServer(t, "unix", filepath.Join(t.TempDir(), "grpcx.sock"))
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{target: a, want: []string{a}},
+		{target: "dns:///" + a, want: []string{a}},
+		{target: "passthrough:///" + a, want: []string{a}},
+		{target: "static:///" + a + "," + b, want: []string{a, b}},
+		{target: "unix://" + sock, want: []string{sock}},
+	}
+	for _, tt := range tests {
+		c, err := grpcx.Dial(tt.target)
+		if err != nil {
+			t.Fatalf("dialing %q: %v", tt.target, err)
+		}
+		client := api.NewEchoServiceClient(c)
+		var got []string
+		for range 2 * len(tt.want) {
+			resp, err := client.Echo(context.Background(), &api.EchoRequest{})
+			if err != nil {
+				t.Fatalf("calling %q: %v", tt.target, err)
+			}
+			if !slices.Contains(got, resp.Message) {
+				got = append(got, resp.Message)
+			}
+		}
+		c.Close()
+		slices.Sort(got)
+		slices.Sort(tt.want)
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("calls to %q served by %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+// backendHandler replies with the address of the backend serving the call.
+type backendHandler struct {
+	api.UnimplementedEchoServiceServer
+	addr string
+}
+
+func (h *backendHandler) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	return &api.EchoResponse{Message: h.addr}, nil
+}
+
+// newBackendServer starts a server replying with its address and returns the address.
+func newBackendServer(t *testing.T, network, address string) string {
+	t.Helper()
+	lis := listen(t, network, address)
+	return serveTestServer(t, lis, func(s *grpcx.Server) {
+		api.RegisterEchoServiceServer(s, &backendHandler{addr: lis.Addr().String()})
+	})
+}
+
+// TestDialTargetResolverError checks a target whose resolver cannot be built
+// fails to dial instead of being resolved using DNS.
+func TestDialTargetResolverError(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	_, err := grpcx.Dial("k8s:///svc.ns:8080")
+	if err == nil || !strings.Contains(err.Error(), "not running in a kubernetes cluster") {
+		t.Fatalf("got %v, want the error of the k8s resolver", err)
+	}
+}
+
+// TestDialSubset checks a client keeps the subset of the backends set with
+// WithSubset, without affecting the other clients of the target.
+func TestDialSubset(t *testing.T) {
+	var backends []string
+	for range 4 {
+		backends = append(backends, newBackendServer(t, "tcp", "127.0.0.1:0"))
+	}
+	target := "static:///" + strings.Join(backends, ",")
+	tests := []struct {
+		opts []grpcx.Option
+		want int
+	}{
+		{opts: []grpcx.Option{grpcx.WithSubset("client-1", 2)}, want: 2},
+		{want: 4},
+	}
+	for _, tt := range tests {
+		c, err := grpcx.Dial(target, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := api.NewEchoServiceClient(c)
+		var got []string
+		for range 4 * len(backends) {
+			resp, err := client.Echo(context.Background(), &api.EchoRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Contains(got, resp.Message) {
+				got = append(got, resp.Message)
+			}
+		}
+		c.Close()
+		if len(got) != tt.want {
+			t.Fatalf("calls served by %v, want %d backends", got, tt.want)
+		}
+	}
+}